@@ -5,14 +5,20 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/komaldsukhani/reverseproxyexample/internal/admin"
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
 	"github.com/komaldsukhani/reverseproxyexample/internal/config"
 	rproxy "github.com/komaldsukhani/reverseproxyexample/internal/reverseproxy"
+	"github.com/komaldsukhani/reverseproxyexample/internal/tlsconfig"
+	"github.com/komaldsukhani/reverseproxyexample/internal/warm"
 )
 
 func main() {
@@ -27,30 +33,115 @@ func main() {
 	setupLogger(&config)
 	slog.Debug("Configured logger", "loglevel", config.LogLevel)
 
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		if err := runWarm(&config, os.Args[2:]); err != nil {
+			slog.Error("failed to warm the cache", "err", err)
+
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	addr := fmt.Sprintf(":%d", config.Proxy.Server.ListenPort)
 
-	p := rproxy.New(&config)
+	p, err := rproxy.New(&config)
+	if err != nil {
+		slog.Error("failed to create reverse proxy", "err", err)
+
+		return
+	}
+
+	// HTTP/2 is negotiated over TLS, and accepted without TLS with H2C.
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(config.Proxy.Server.H2C)
+
+	http2Config := &http.HTTP2Config{MaxConcurrentStreams: config.Proxy.Server.MaxConcurrentStreams}
 
 	srv := http.Server{
 		Addr:         addr,
 		Handler:      p,
+		Protocols:    &protocols,
+		HTTP2:        http2Config,
 		ReadTimeout:  config.Proxy.Server.ReadTimeout,
 		WriteTimeout: config.Proxy.Server.WriteTimeout,
 		IdleTimeout:  config.Proxy.Server.IdleTimeout,
 	}
 
-	go func() {
-		slog.Info("Started server", "addr", srv.Addr)
+	servers := []*http.Server{&srv}
+
+	var watcher *tlsconfig.Watcher
+
+	if len(config.Proxy.TLS.CertFiles) > 0 {
+		tlsConfig, reloaders, err := tlsconfig.NewServer(&config.Proxy.TLS)
+		if err != nil {
+			slog.Error("failed to configure tls", "err", err)
 
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("failed to start server: %v", err)
+			return
 		}
-	}()
 
-	gracefulShutdown(&srv, &config)
+		watcher = tlsconfig.Watch(config.Proxy.TLS.ReloadInterval, reloaders...)
+
+		servers = append(servers, &http.Server{
+			Addr:         fmt.Sprintf(":%d", config.Proxy.TLS.ListenPort),
+			Handler:      p,
+			TLSConfig:    tlsConfig,
+			HTTP2:        http2Config,
+			ReadTimeout:  config.Proxy.Server.ReadTimeout,
+			WriteTimeout: config.Proxy.Server.WriteTimeout,
+			IdleTimeout:  config.Proxy.Server.IdleTimeout,
+		})
+	}
+
+	if config.Admin.Enabled {
+		servers = append(servers, &http.Server{
+			Addr:         net.JoinHostPort(config.Admin.ListenHost, strconv.Itoa(config.Admin.ListenPort)),
+			Handler:      admin.New(p.Cache, warm.New(p, warm.Options{Concurrency: config.Cache.Warm.Concurrency, Rate: config.Cache.Warm.Rate})),
+			ReadTimeout:  config.Proxy.Server.ReadTimeout,
+			WriteTimeout: config.Proxy.Server.WriteTimeout,
+			IdleTimeout:  config.Proxy.Server.IdleTimeout,
+		})
+	}
+
+	if peers, ok := p.Cache.(*cache.PeerStore); ok {
+		servers = append(servers, &http.Server{
+			Addr:         net.JoinHostPort(config.Cache.Peers.ListenHost, strconv.Itoa(config.Cache.Peers.ListenPort)),
+			Handler:      peers.Handler(),
+			ReadTimeout:  config.Proxy.Server.ReadTimeout,
+			WriteTimeout: config.Proxy.Server.WriteTimeout,
+			IdleTimeout:  config.Proxy.Server.IdleTimeout,
+		})
+	}
+
+	for _, srv := range servers {
+		go func() {
+			slog.Info("Started server", "addr", srv.Addr)
+
+			var err error
+			if srv.TLSConfig != nil {
+				// The certificates come from TLSConfig.GetCertificate.
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+
+			if err != http.ErrServerClosed {
+				log.Fatalf("failed to start server: %v", err)
+			}
+		}()
+	}
+
+	gracefulShutdown(servers, &config)
+
+	watcher.Close()
+
+	if err := p.Close(); err != nil {
+		slog.Error("failed to close the cache", "error", err)
+	}
 }
 
-func gracefulShutdown(srv *http.Server, config *config.Config) {
+func gracefulShutdown(servers []*http.Server, config *config.Config) {
 	var quit = make(chan os.Signal, 1)
 
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -62,8 +153,10 @@ func gracefulShutdown(srv *http.Server, config *config.Config) {
 	ctx, cancel := context.WithTimeout(context.Background(), config.Proxy.Server.ShutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("failed to shutdown the server: %v", err)
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Fatalf("failed to shutdown the server: %v", err)
+		}
 	}
 }
 
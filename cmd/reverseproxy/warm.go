@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	rproxy "github.com/komaldsukhani/reverseproxyexample/internal/reverseproxy"
+	"github.com/komaldsukhani/reverseproxyexample/internal/warm"
+)
+
+// runWarm implements the warm subcommand. It requests the URLs listed in a
+// file or sitemap through a proxy built from the same configuration, so it
+// fills shared cache backends, or the snapshot file saved when it closes.
+func runWarm(config *config.Config, args []string) error {
+	flags := flag.NewFlagSet("warm", flag.ContinueOnError)
+	concurrency := flags.Int("concurrency", config.Cache.Warm.Concurrency, "number of requests in flight at once")
+	rate := flags.Float64("rate", config.Cache.Warm.Rate, "maximum number of requests per second, 0 for no limit")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s warm [flags] <url list or sitemap, as a file or http(s) URL>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+
+		return errors.New("expected a single url list or sitemap")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	urls, err := warm.Load(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	p, err := rproxy.New(config)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := p.Close(); err != nil {
+			slog.Error("failed to close the cache", "error", err)
+		}
+	}()
+
+	results := warm.New(p, warm.Options{Concurrency: *concurrency, Rate: *rate}).Warm(ctx, urls)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tSIZE\tCACHEABLE\tURL\tERROR")
+
+	cacheable := 0
+	for _, r := range results {
+		if r.Cacheable {
+			cacheable++
+		}
+
+		fmt.Fprintf(w, "%d\t%d\t%t\t%s\t%s\n", r.Status, r.Size, r.Cacheable, r.URL, r.Error)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nWarmed %d URLs, %d cacheable\n", len(results), cacheable)
+
+	return nil
+}
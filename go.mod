@@ -3,6 +3,8 @@ module github.com/komaldsukhani/reverseproxyexample
 go 1.25.5
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/klauspost/compress v1.18.0
 	github.com/matryer/is v1.4.1
 )
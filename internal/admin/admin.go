@@ -0,0 +1,245 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/warm"
+)
+
+const defaultPageLimit = 100
+
+type Handler struct {
+	store  cache.Store
+	warmer *warm.Warmer
+	mux    *http.ServeMux
+}
+
+type keysResponse struct {
+	Total   int         `json:"total"`
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+	Entries []entryInfo `json:"entries"`
+}
+
+type entryInfo struct {
+	Key          string  `json:"key"`
+	StatusCode   int     `json:"statusCode"`
+	Size         int     `json:"size"`
+	AgeSeconds   float64 `json:"ageSeconds"`
+	TTLRemaining float64 `json:"ttlRemainingSeconds"`
+	Hits         int64   `json:"hits"`
+}
+
+type warmResponse struct {
+	Total     int           `json:"total"`
+	Cacheable int           `json:"cacheable"`
+	Failed    int           `json:"failed"`
+	Results   []warm.Result `json:"results"`
+}
+
+// New returns the admin API of store. Warming is answered with 501 when
+// warmer is nil.
+func New(store cache.Store, warmer *warm.Warmer) *Handler {
+	h := &Handler{
+		store:  store,
+		warmer: warmer,
+		mux:    http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /cache/stats", h.stats)
+	h.mux.HandleFunc("GET /cache/keys", h.keys)
+	h.mux.HandleFunc("DELETE /cache/keys", h.purge)
+	h.mux.HandleFunc("POST /cache/warm", h.warm)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(rw, r)
+}
+
+func (h *Handler) stats(rw http.ResponseWriter, r *http.Request) {
+	stats, err := h.store.Stats(r.Context())
+	if err != nil {
+		slog.Error("failed to read cache stats", "error", err)
+
+		http.Error(rw, "failed to read cache stats", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(rw, stats)
+}
+
+// keys lists cached records. Supported query parameters are prefix, offset and limit.
+func (h *Handler) keys(rw http.ResponseWriter, r *http.Request) {
+	lister, ok := h.store.(cache.Lister)
+	if !ok {
+		http.Error(rw, "cache backend does not support listing keys", http.StatusNotImplemented)
+		return
+	}
+
+	q := r.URL.Query()
+
+	offset, err := intParam(q.Get("offset"), 0)
+	if err != nil {
+		http.Error(rw, "invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := intParam(q.Get("limit"), defaultPageLimit)
+	if err != nil {
+		http.Error(rw, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	entries, total, err := lister.Entries(r.Context(), memcache.EntryFilter{
+		Prefix: q.Get("prefix"),
+		Offset: offset,
+		Limit:  limit,
+	})
+	if err != nil {
+		slog.Error("failed to list cache keys", "error", err)
+
+		http.Error(rw, "failed to list cache keys", http.StatusInternalServerError)
+		return
+	}
+
+	resp := keysResponse{
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Entries: make([]entryInfo, 0, len(entries)),
+	}
+
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, entryInfo{
+			Key:          e.Key,
+			StatusCode:   e.StatusCode,
+			Size:         e.Size,
+			AgeSeconds:   e.Age.Seconds(),
+			TTLRemaining: e.TTLRemaining.Seconds(),
+			Hits:         e.Hits,
+		})
+	}
+
+	writeJSON(rw, resp)
+}
+
+// purge removes a single record when the key query parameter is set, otherwise the whole cache.
+func (h *Handler) purge(rw http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("key") {
+		purger, ok := h.store.(cache.Purger)
+		if !ok {
+			http.Error(rw, "cache backend does not support purging", http.StatusNotImplemented)
+			return
+		}
+
+		n, err := purger.Purge(r.Context())
+		if err != nil {
+			slog.Error("failed to purge the cache", "error", err)
+
+			http.Error(rw, "failed to purge the cache", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("Purged the cache", "records", n)
+
+		writeJSON(rw, map[string]int{"purged": n})
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+
+	// Removing the record tells whether it existed without counting as a
+	// lookup, and purges expired records too.
+	removed, err := cache.Remove(r.Context(), h.store, key)
+	if err != nil {
+		slog.Error("failed to purge cache record", "key", key, "error", err)
+
+		http.Error(rw, "failed to purge cache record", http.StatusInternalServerError)
+		return
+	}
+
+	if !removed {
+		http.Error(rw, "key not found", http.StatusNotFound)
+		return
+	}
+
+	slog.Info("Purged cache record", "key", key)
+
+	writeJSON(rw, map[string]int{"purged": 1})
+}
+
+// warm requests the URLs listed in the request body, one per line or as a
+// sitemap, through the cache and reports the outcome of each. The request
+// lasts as long as warming does, however long the server's write timeout.
+func (h *Handler) warm(rw http.ResponseWriter, r *http.Request) {
+	if h.warmer == nil {
+		http.Error(rw, "cache warming is not available", http.StatusNotImplemented)
+		return
+	}
+
+	urls, err := warm.ReadURLs(r.Body)
+	if errors.Is(err, warm.ErrListTooLarge) {
+		http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(rw, "invalid url list: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Warming is bounded by its rate and concurrency instead. A client that
+	// gives up cancels it.
+	if err := http.NewResponseController(rw).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Warn("failed to lift the write timeout of cache warming", "error", err)
+	}
+
+	results := h.warmer.Warm(r.Context(), urls)
+
+	resp := warmResponse{
+		Total:   len(results),
+		Results: results,
+	}
+
+	for _, res := range results {
+		if res.Cacheable {
+			resp.Cacheable++
+		}
+
+		if res.Error != "" || res.Status >= http.StatusInternalServerError {
+			resp.Failed++
+		}
+	}
+
+	slog.Info("Warmed the cache", "urls", resp.Total, "cacheable", resp.Cacheable, "failed", resp.Failed)
+
+	writeJSON(rw, resp)
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, strconv.ErrSyntax
+	}
+
+	return n, nil
+}
+
+func writeJSON(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		slog.Error("failed to write admin response", "error", err)
+	}
+}
@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/reverseproxy"
+	"github.com/komaldsukhani/reverseproxyexample/internal/warm"
+	"github.com/matryer/is"
+)
+
+func TestKeys(t *testing.T) {
+	eval := is.New(t)
+
+	c := memcache.NewMemoryCache(30*time.Second, 10000, 1000)
+	eval.NoErr(c.Set("GET:/a", &memcache.Record{StatusCode: http.StatusOK}))
+	eval.NoErr(c.Set("GET:/b", &memcache.Record{StatusCode: http.StatusOK}))
+	eval.NoErr(c.Set("HEAD:/a", &memcache.Record{StatusCode: http.StatusOK}))
+
+	rec := httptest.NewRecorder()
+	New(cache.NewMemoryStore(c), nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/keys?prefix=GET:&limit=1&offset=1", nil))
+	eval.Equal(rec.Code, http.StatusOK)
+
+	var resp keysResponse
+	eval.NoErr(json.NewDecoder(rec.Body).Decode(&resp))
+	eval.Equal(resp.Total, 2)
+	eval.Equal(len(resp.Entries), 1)
+	eval.Equal(resp.Entries[0].Key, "GET:/b")
+
+	rec = httptest.NewRecorder()
+	New(cache.NewMemoryStore(c), nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/keys?limit=abc", nil))
+	eval.Equal(rec.Code, http.StatusBadRequest)
+}
+
+func TestPurge(t *testing.T) {
+	eval := is.New(t)
+
+	c := memcache.NewMemoryCache(30*time.Second, 10000, 1000)
+	eval.NoErr(c.Set("GET:/a", &memcache.Record{}))
+	eval.NoErr(c.Set("GET:/b", &memcache.Record{}))
+	eval.NoErr(c.SetWithTTL("GET:/expired", &memcache.Record{}, time.Millisecond))
+
+	time.Sleep(5 * time.Millisecond)
+
+	h := New(cache.NewMemoryStore(c), nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/cache/keys?key=GET:/a", nil))
+	eval.Equal(rec.Code, http.StatusOK)
+	eval.Equal(c.Count(), 2)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/cache/keys?key=GET:/a", nil))
+	eval.Equal(rec.Code, http.StatusNotFound)
+
+	// An expired record is still there to purge.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/cache/keys?key=GET:/expired", nil))
+	eval.Equal(rec.Code, http.StatusOK)
+	eval.Equal(c.Count(), 1)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/cache/keys", nil))
+	eval.Equal(rec.Code, http.StatusOK)
+	eval.Equal(c.Count(), 0)
+
+	var stats memcache.Stats
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/stats", nil))
+	eval.NoErr(json.NewDecoder(rec.Body).Decode(&stats))
+	eval.Equal(stats.Evictions.Purged, int64(3))
+
+	// Purging isn't a lookup.
+	eval.Equal(stats.Hits, int64(0))
+	eval.Equal(stats.Misses, int64(0))
+}
+
+func TestWarm(t *testing.T) {
+	eval := is.New(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private")
+		}
+
+		_, _ = w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+
+	p, err := reverseproxy.New(&config.Config{
+		Proxy: config.ProxyConfig{TargetURL: upstream.URL},
+		Cache: config.CacheConfig{TTL: time.Minute, MaxSize: 64 * 1024, MaxRecordSize: 1024},
+	})
+	eval.NoErr(err)
+
+	h := New(p.Cache, warm.New(p, warm.Options{Concurrency: 2}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/warm", strings.NewReader("/a\nhttps://example.com/b?x=1\n/private\n")))
+	eval.Equal(rec.Code, http.StatusOK)
+
+	var resp warmResponse
+	eval.NoErr(json.NewDecoder(rec.Body).Decode(&resp))
+	eval.Equal(resp.Total, 3)
+	eval.Equal(resp.Cacheable, 2)
+	eval.Equal(resp.Failed, 0)
+	eval.Equal(resp.Results[1], warm.Result{URL: "https://example.com/b?x=1", Status: http.StatusOK, Size: 4, Cacheable: true})
+
+	// stored under the keys of regular requests
+	_, err = p.Cache.Get(context.Background(), "GET:/b?x=1")
+	eval.NoErr(err)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/warm", strings.NewReader("<sitemapindex/>")))
+	eval.Equal(rec.Code, http.StatusBadRequest)
+
+	rec = httptest.NewRecorder()
+	New(p.Cache, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/warm", strings.NewReader("/a")))
+	eval.Equal(rec.Code, http.StatusNotImplemented)
+}
+
+func TestWarmOutlivesWriteTimeout(t *testing.T) {
+	eval := is.New(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+
+	p, err := reverseproxy.New(&config.Config{
+		Proxy: config.ProxyConfig{TargetURL: upstream.URL},
+		Cache: config.CacheConfig{TTL: time.Minute, MaxSize: 64 * 1024, MaxRecordSize: 1024},
+	})
+	eval.NoErr(err)
+
+	srv := httptest.NewUnstartedServer(New(p.Cache, warm.New(p, warm.Options{Concurrency: 1})))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/cache/warm", "text/plain", strings.NewReader("/a\n/b\n"))
+	eval.NoErr(err)
+	defer resp.Body.Close()
+
+	var warmed warmResponse
+	eval.NoErr(json.NewDecoder(resp.Body).Decode(&warmed))
+	eval.Equal(warmed.Total, 2)
+	eval.Equal(warmed.Cacheable, 2)
+}
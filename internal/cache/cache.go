@@ -0,0 +1,226 @@
+// Package cache defines the storage interface used by the reverse proxy to
+// keep cached responses, along with the available backends.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+const BackendMemory = "memory"
+
+var (
+	ErrNotFound = errors.New("record not found")
+	// ErrExpired is returned by Get when the record existed but was no longer fresh.
+	ErrExpired = fmt.Errorf("%w: record expired", ErrNotFound)
+)
+
+type (
+	Record = memcache.Record
+	Stats  = memcache.Stats
+)
+
+// Store is a cache backend keeping response records by key.
+type Store interface {
+	// Get returns the record stored under key or an error wrapping ErrNotFound.
+	Get(ctx context.Context, key string) (*Record, error)
+	// Set stores r under key for ttl.
+	Set(ctx context.Context, key string, r *Record, ttl time.Duration) error
+	// Delete removes the record stored under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	Stats(ctx context.Context) (Stats, error)
+}
+
+// Lister is implemented by stores that can enumerate their records.
+type Lister interface {
+	Entries(ctx context.Context, f memcache.EntryFilter) ([]memcache.EntryInfo, int, error)
+}
+
+// Purger is implemented by stores that can drop all of their records at once.
+type Purger interface {
+	Purge(ctx context.Context) (int, error)
+}
+
+// Remover is implemented by stores that can tell whether deleting a key
+// removed a record, which Get can't without counting as a lookup.
+type Remover interface {
+	// Remove deletes the record stored under key, fresh or expired, and
+	// reports whether there was one.
+	Remove(ctx context.Context, key string) (bool, error)
+}
+
+// Remove deletes the record stored under key from s and reports whether
+// there was one. Stores that can't tell report true.
+func Remove(ctx context.Context, s Store, key string) (bool, error) {
+	if r, ok := s.(Remover); ok {
+		return r.Remove(ctx, key)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Close releases the resources of s, such as background goroutines and
+// connections, if it holds any.
+func Close(s Store) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// New creates the store selected by config.Cache.Backend.
+func New(config *config.Config) (Store, error) {
+	switch config.Cache.Backend {
+	case BackendMemory, "":
+		return newMemoryStore(config)
+	case BackendDisk:
+		return newDiskStore(config)
+	case BackendTiered:
+		disk, err := newDiskStore(config)
+		if err != nil {
+			return nil, err
+		}
+
+		memory, err := newMemoryStore(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewTieredStore(memory, disk), nil
+	case BackendRedis:
+		return newRedisStore(config), nil
+	case BackendMemcached:
+		return newMemcachedStore(config), nil
+	case BackendPeers:
+		return newPeerStore(config)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", config.Cache.Backend)
+	}
+}
+
+func newMemoryStore(config *config.Config) (*MemoryStore, error) {
+	if _, err := memcache.NewPolicy(config.Cache.EvictionPolicy); err != nil {
+		return nil, err
+	}
+
+	newPolicy := func() memcache.Policy {
+		policy, _ := memcache.NewPolicy(config.Cache.EvictionPolicy)
+
+		return policy
+	}
+
+	maxSize := config.Cache.MaxSize
+
+	var memoryLimit int64
+	if ratio := config.Cache.MemoryLimitRatio; ratio != 0 {
+		if ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("memory limit ratio %v is not between 0 and 1", ratio)
+		}
+
+		memoryLimit = memcache.MemoryLimit()
+		if memoryLimit == 0 {
+			return nil, errors.New("memory limit ratio is set but the Go runtime has no memory limit, set GOMEMLIMIT")
+		}
+
+		maxSize = int(float64(memoryLimit) * ratio)
+	}
+
+	c := memcache.NewShardedMemoryCache(config.Cache.TTL, maxSize, config.Cache.MaxRecordSize, config.Cache.Shards, newPolicy)
+
+	if config.Cache.SweepInterval > 0 {
+		c.StartJanitor(config.Cache.SweepInterval, config.Cache.SweepLimit)
+	}
+
+	if memoryLimit > 0 {
+		c.StartMemoryMonitor(config.Cache.MemoryCheckInterval, memoryLimit)
+	}
+
+	store := NewMemoryStore(c)
+
+	if path := config.Cache.SnapshotFile; path != "" {
+		// A snapshot that can't be restored only costs a cold start.
+		if n, err := store.LoadSnapshot(path); err != nil {
+			slog.Error("failed to restore cache snapshot, starting empty", "path", path, "error", err)
+		} else {
+			slog.Info("Restored cache snapshot", "path", path, "records", n)
+		}
+
+		store.StartSnapshots(path, config.Cache.SnapshotInterval)
+	}
+
+	return store, nil
+}
+
+func newDiskStore(config *config.Config) (*DiskStore, error) {
+	return NewDiskStore(config.Cache.Disk.Dir, int64(config.Cache.Disk.MaxSize), int64(config.Cache.Disk.MaxRecordSize))
+}
+
+func newRedisStore(config *config.Config) *RedisStore {
+	return NewRedisStore(RedisOptions{
+		Addr:          config.Cache.Redis.Addr,
+		Password:      config.Cache.Redis.Password,
+		DB:            config.Cache.Redis.DB,
+		KeyPrefix:     config.Cache.Redis.KeyPrefix,
+		PoolSize:      config.Cache.Redis.PoolSize,
+		MaxRecordSize: config.Cache.Redis.MaxRecordSize,
+		DialTimeout:   config.Cache.Redis.DialTimeout,
+		ReadTimeout:   config.Cache.Redis.ReadTimeout,
+		WriteTimeout:  config.Cache.Redis.WriteTimeout,
+		RetryBackoff:  config.Cache.Redis.RetryBackoff,
+	})
+}
+
+func newMemcachedStore(config *config.Config) *MemcachedStore {
+	return NewMemcachedStore(MemcachedOptions{
+		Servers:       config.Cache.Memcached.Servers,
+		KeyPrefix:     config.Cache.Memcached.KeyPrefix,
+		PoolSize:      config.Cache.Memcached.PoolSize,
+		MaxItemSize:   config.Cache.Memcached.MaxItemSize,
+		MaxRecordSize: config.Cache.Memcached.MaxRecordSize,
+		DialTimeout:   config.Cache.Memcached.DialTimeout,
+		ReadTimeout:   config.Cache.Memcached.ReadTimeout,
+		WriteTimeout:  config.Cache.Memcached.WriteTimeout,
+		RetryBackoff:  config.Cache.Memcached.RetryBackoff,
+	})
+}
+
+func newPeerStore(config *config.Config) (*PeerStore, error) {
+	local, err := newMemoryStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	hot := memcache.NewMemoryCache(config.Cache.Peers.HotTTL, config.Cache.MaxSize/8, config.Cache.MaxRecordSize)
+
+	store, err := NewPeerStore(PeerOptions{
+		Self:            config.Cache.Peers.Self,
+		Peers:           config.Cache.Peers.URLs,
+		PeersFile:       config.Cache.Peers.File,
+		RefreshInterval: config.Cache.Peers.RefreshInterval,
+		HotThreshold:    config.Cache.Peers.HotThreshold,
+		HotTTL:          config.Cache.Peers.HotTTL,
+		Timeout:         config.Cache.Peers.Timeout,
+		RetryBackoff:    config.Cache.Peers.RetryBackoff,
+		Secret:          config.Cache.Peers.Secret,
+	}, local, NewMemoryStore(hot))
+	if err != nil {
+		_ = local.Close()
+		_ = hot.Close()
+
+		return nil, err
+	}
+
+	return store, nil
+}
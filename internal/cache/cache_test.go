@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestNew(t *testing.T) {
+	eval := is.New(t)
+
+	var cfg config.Config
+	cfg.SetDefaults()
+
+	store, err := New(&cfg)
+	eval.NoErr(err)
+
+	_, ok := store.(*MemoryStore)
+	eval.True(ok)
+
+	cfg.Cache.EvictionPolicy = "wtinylfu"
+
+	_, err = New(&cfg)
+	eval.NoErr(err)
+
+	cfg.Cache.EvictionPolicy = "unknown"
+
+	_, err = New(&cfg)
+	eval.True(err != nil)
+
+	cfg.Cache.EvictionPolicy = "lru"
+	cfg.Cache.Backend = "unknown"
+
+	_, err = New(&cfg)
+	eval.True(err != nil)
+}
+
+func TestNewMemoryLimit(t *testing.T) {
+	eval := is.New(t)
+
+	var cfg config.Config
+	cfg.SetDefaults()
+	cfg.Cache.MemoryLimitRatio = 0.25
+
+	prev := debug.SetMemoryLimit(math.MaxInt64)
+	defer debug.SetMemoryLimit(prev)
+
+	// there is no memory limit to size the cache from
+	_, err := New(&cfg)
+	eval.True(err != nil)
+
+	debug.SetMemoryLimit(64 * 1024 * 1024)
+
+	store, err := New(&cfg)
+	eval.NoErr(err)
+	defer func() { _ = Close(store) }()
+
+	stats, err := store.Stats(context.Background())
+	eval.NoErr(err)
+	eval.Equal(stats.Capacity, 16*1024*1024)
+
+	cfg.Cache.MemoryLimitRatio = 1.5
+
+	_, err = New(&cfg)
+	eval.True(err != nil)
+}
+
+func TestMemoryStore(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	var cfg config.Config
+	cfg.SetDefaults()
+
+	store, err := New(&cfg)
+	eval.NoErr(err)
+
+	_, err = store.Get(ctx, "k")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	eval.NoErr(store.Set(ctx, "k", &Record{StatusCode: 200, Body: []byte("body")}, 10*time.Millisecond))
+
+	r, err := store.Get(ctx, "k")
+	eval.NoErr(err)
+	eval.Equal(r.Body, []byte("body"))
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = store.Get(ctx, "k")
+	eval.True(errors.Is(err, ErrExpired))
+	eval.True(errors.Is(err, ErrNotFound))
+
+	eval.NoErr(store.Set(ctx, "k", &Record{}, time.Minute))
+	eval.NoErr(store.Delete(ctx, "k"))
+	eval.NoErr(store.Delete(ctx, "k"))
+
+	stats, err := store.Stats(ctx)
+	eval.NoErr(err)
+	eval.Equal(stats.Hits, int64(1))
+	eval.Equal(stats.StaleHits, int64(1))
+	eval.Equal(stats.Evictions.Purged, int64(1))
+	eval.Equal(stats.Records, 0)
+}
+
+func TestMemoryStoreSnapshot(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	var cfg config.Config
+	cfg.SetDefaults()
+	cfg.Cache.SnapshotFile = filepath.Join(t.TempDir(), "cache.snapshot")
+	cfg.Cache.SnapshotInterval = 10 * time.Millisecond
+
+	store, err := New(&cfg)
+	eval.NoErr(err)
+
+	eval.NoErr(store.Set(ctx, "fresh", &Record{StatusCode: 200, Body: []byte("fresh")}, time.Minute))
+	eval.NoErr(store.Set(ctx, "expiring", &Record{StatusCode: 200}, 30*time.Millisecond))
+
+	// saved periodically
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = os.Stat(cfg.Cache.SnapshotFile)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	eval.NoErr(err)
+
+	// and on shutdown
+	eval.NoErr(Close(store))
+
+	time.Sleep(30 * time.Millisecond)
+
+	store, err = New(&cfg)
+	eval.NoErr(err)
+
+	r, err := store.Get(ctx, "fresh")
+	eval.NoErr(err)
+	eval.Equal(r.Body, []byte("fresh"))
+	eval.True(r.TTL(time.Now()) > 50*time.Second)
+
+	_, err = store.Get(ctx, "expiring")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	eval.NoErr(Close(store))
+
+	// a corrupted snapshot is ignored
+	data, err := os.ReadFile(cfg.Cache.SnapshotFile)
+	eval.NoErr(err)
+
+	data[len(data)/2] ^= 0xff
+	eval.NoErr(os.WriteFile(cfg.Cache.SnapshotFile, data, 0o644))
+
+	store, err = New(&cfg)
+	eval.NoErr(err)
+	defer func() { _ = Close(store) }()
+
+	stats, err := store.Stats(ctx)
+	eval.NoErr(err)
+	eval.Equal(stats.Records, 0)
+}
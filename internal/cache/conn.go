@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// connPool bounds the number of open connections and keeps idle ones for reuse.
+type connPool struct {
+	dial func(ctx context.Context) (*bufConn, error)
+	sem  chan struct{}
+
+	mu     sync.Mutex
+	idle   []*bufConn
+	closed bool
+}
+
+func newConnPool(size int, dial func(ctx context.Context) (*bufConn, error)) *connPool {
+	return &connPool{
+		dial: dial,
+		sem:  make(chan struct{}, max(size, 1)),
+	}
+}
+
+func (p *connPool) Get(ctx context.Context) (*bufConn, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.sem
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Put returns c to the pool. A nil c releases the slot of a broken connection.
+func (p *connPool) Put(c *bufConn) {
+	defer func() { <-p.sem }()
+
+	if c == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		_ = c.Close()
+
+		return
+	}
+
+	p.idle = append(p.idle, c)
+}
+
+func (p *connPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+
+	var errs []error
+	for _, c := range p.idle {
+		errs = append(errs, c.Close())
+	}
+
+	p.idle = nil
+
+	return errors.Join(errs...)
+}
+
+type bufConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func dialConn(ctx context.Context, addr string, timeout time.Duration) (*bufConn, error) {
+	d := net.Dialer{Timeout: timeout}
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bufConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}, nil
+}
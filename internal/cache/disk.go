@@ -0,0 +1,514 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+const (
+	BackendDisk   = "disk"
+	BackendTiered = "tiered"
+
+	diskFileMagic = "RPC1"
+	diskTmpDir    = "tmp"
+)
+
+// DiskStore keeps records as files below a directory bounded by total size.
+//
+// Files are content addressed: each file is named after the SHA-256 of its
+// contents, which hold the key followed by the encoded record. The in-memory
+// index mapping keys to files is rebuilt from the directory on startup, and
+// files whose contents no longer match their name are discarded.
+type DiskStore struct {
+	dir           string
+	maxSize       int64
+	maxRecordSize int64
+
+	mu    sync.Mutex
+	index map[string]*diskEntry
+	ll    *list.List // front is the most recently used key
+	used  int64
+	stats Stats
+}
+
+type diskEntry struct {
+	key        string
+	hash       string
+	statusCode int
+	size       int64
+	storedAt   time.Time
+	expiry     time.Time
+	hits       int64
+	element    *list.Element
+}
+
+func NewDiskStore(dir string, maxSize, maxRecordSize int64) (*DiskStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, diskTmpDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	s := &DiskStore{
+		dir:           dir,
+		maxSize:       maxSize,
+		maxRecordSize: maxRecordSize,
+		index:         make(map[string]*diskEntry),
+		ll:            list.New(),
+	}
+
+	if err := s.rebuildIndex(); err != nil {
+		return nil, fmt.Errorf("failed to rebuild cache index: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *DiskStore) Get(_ context.Context, key string) (*Record, error) {
+	s.mu.Lock()
+
+	e, ok := s.index[key]
+	if !ok {
+		s.stats.Misses++
+		s.mu.Unlock()
+
+		return nil, ErrNotFound
+	}
+
+	if time.Now().After(e.expiry) {
+		s.stats.Misses++
+		s.stats.StaleHits++
+		s.stats.Evictions.Expired++
+		s.removeLocked(e)
+		s.mu.Unlock()
+
+		return nil, ErrExpired
+	}
+
+	s.ll.MoveToFront(e.element)
+	path := s.path(e.hash)
+	s.mu.Unlock()
+
+	_, r, err := readDiskFile(path)
+	if err != nil {
+		// The file was evicted concurrently or is unreadable; treat it as a miss.
+		slog.Debug("failed to read cached file", "key", key, "error", err)
+
+		s.mu.Lock()
+		s.stats.Misses++
+		if cur, ok := s.index[key]; ok && cur == e {
+			s.removeLocked(e)
+		}
+		s.mu.Unlock()
+
+		return nil, ErrNotFound
+	}
+
+	s.mu.Lock()
+	s.stats.Hits++
+	e.hits++
+	s.mu.Unlock()
+
+	// Keep the access time on disk so that LRU order survives a restart.
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return r, nil
+}
+
+func (s *DiskStore) Set(_ context.Context, key string, r *Record, ttl time.Duration) error {
+	r.Stamp(time.Now(), ttl)
+
+	data, err := encodeDiskFile(key, r)
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(data))
+
+	if size > s.maxRecordSize || size > s.maxSize {
+		s.mu.Lock()
+		s.stats.RejectedTooLarge++
+		s.mu.Unlock()
+
+		return memcache.ErrMaxRecordSizeExceed
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if err := s.writeFile(hash, data); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.index[key]; ok {
+		s.removeLocked(old)
+	}
+
+	for s.used+size > s.maxSize && s.ll.Len() != 0 {
+		s.stats.Evictions.Capacity++
+		s.removeLocked(s.index[s.ll.Back().Value.(string)])
+	}
+
+	s.addLocked(&diskEntry{
+		key:        key,
+		hash:       hash,
+		statusCode: r.StatusCode,
+		size:       size,
+		storedAt:   r.StoredAt(),
+		expiry:     r.ExpiresAt(),
+	}, true)
+
+	return nil
+}
+
+func (s *DiskStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Remove(ctx, key)
+
+	return err
+}
+
+func (s *DiskStore) Remove(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.index[key]
+	if ok {
+		s.stats.Evictions.Purged++
+		s.removeLocked(e)
+	}
+
+	return ok, nil
+}
+
+func (s *DiskStore) Purge(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.index)
+
+	for _, e := range s.index {
+		s.removeLocked(e)
+	}
+
+	s.stats.Evictions.Purged += int64(n)
+
+	return n, nil
+}
+
+func (s *DiskStore) Stats(_ context.Context) (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Records = len(s.index)
+	stats.BytesUsed = int(s.used)
+	stats.Capacity = int(s.maxSize)
+
+	return stats, nil
+}
+
+func (s *DiskStore) Entries(_ context.Context, f memcache.EntryFilter) ([]memcache.EntryInfo, int, error) {
+	s.mu.Lock()
+
+	now := time.Now()
+	entries := make([]memcache.EntryInfo, 0, len(s.index))
+
+	for k, e := range s.index {
+		if !strings.HasPrefix(k, f.Prefix) {
+			continue
+		}
+
+		entries = append(entries, memcache.EntryInfo{
+			Key:          k,
+			StatusCode:   e.statusCode,
+			Size:         int(e.size),
+			Age:          max(now.Sub(e.storedAt), 0),
+			TTLRemaining: max(e.expiry.Sub(now), 0),
+			Hits:         e.hits,
+		})
+	}
+
+	s.mu.Unlock()
+
+	entries, total := memcache.PageEntries(entries, f)
+
+	return entries, total, nil
+}
+
+// isDiskShard reports whether name is that of a directory the store keeps
+// files in, the first two hex digits of their hashes.
+func isDiskShard(name string) bool {
+	return len(name) == 2 && isLowerHex(name)
+}
+
+// isDiskFileName reports whether name is that of a file the store writes,
+// the hex encoded SHA-256 of its contents.
+func isDiskFileName(name string) bool {
+	return len(name) == hex.EncodedLen(sha256.Size) && isLowerHex(name)
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range []byte(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// removeDiskFile removes a file of the store found on startup, logging
+// rather than failing if it can't.
+func removeDiskFile(path string) {
+	if err := os.Remove(path); err != nil {
+		slog.Warn("Failed to remove cache file", "path", path, "error", err)
+	}
+}
+
+func (s *DiskStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// writeFile atomically writes data to the file named hash.
+func (s *DiskStore) writeFile(hash string, data []byte) error {
+	final := s.path(hash)
+
+	if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, diskTmpDir), hash+"-*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), final)
+}
+
+func (s *DiskStore) addLocked(e *diskEntry, front bool) {
+	if front {
+		e.element = s.ll.PushFront(e.key)
+	} else {
+		e.element = s.ll.PushBack(e.key)
+	}
+
+	s.index[e.key] = e
+	s.used += e.size
+}
+
+func (s *DiskStore) removeLocked(e *diskEntry) {
+	s.ll.Remove(e.element)
+	delete(s.index, e.key)
+	s.used -= e.size
+
+	if err := os.Remove(s.path(e.hash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("failed to remove cached file", "key", e.key, "error", err)
+	}
+}
+
+// rebuildIndex scans the cache directory, dropping leftover temporary files,
+// corrupted or expired files and older duplicates of the same key. Records are
+// ordered for eviction by the file modification time, which Get refreshes.
+// Only the files laid out as the store writes them are touched: anything
+// else in the directory is logged and left alone, and so are the files that
+// can't be read or removed.
+func (s *DiskStore) rebuildIndex() error {
+	tmpDir := filepath.Join(s.dir, diskTmpDir)
+
+	type found struct {
+		entry   *diskEntry
+		modTime time.Time
+	}
+
+	var files []found
+
+	now := time.Now()
+
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if path == s.dir {
+			return err
+		}
+
+		if err != nil {
+			slog.Warn("Skipping unreadable path in the disk cache directory", "path", path, "error", err)
+
+			return nil
+		}
+
+		dir, name := filepath.Dir(path), d.Name()
+
+		if d.IsDir() {
+			if dir == s.dir && (name == diskTmpDir || isDiskShard(name)) {
+				return nil
+			}
+
+			slog.Warn("Skipping unknown directory in the disk cache directory", "path", path)
+
+			return filepath.SkipDir
+		}
+
+		if dir == tmpDir {
+			removeDiskFile(path)
+
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !isDiskFileName(name) || filepath.Base(dir) != name[:2] {
+			slog.Warn("Skipping unknown file in the disk cache directory", "path", path)
+
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			slog.Warn("Skipping unreadable cache file", "path", path, "error", err)
+
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("Skipping unreadable cache file", "path", path, "error", err)
+
+			return nil
+		}
+
+		sum := sha256.Sum256(data)
+
+		key, r, err := decodeDiskFile(data)
+		if err != nil || name != hex.EncodeToString(sum[:]) || r.Expired(now) {
+			slog.Debug("Removing invalid or expired cache file", "path", path, "error", err)
+			removeDiskFile(path)
+
+			return nil
+		}
+
+		files = append(files, found{
+			entry: &diskEntry{
+				key:        key,
+				hash:       filepath.Base(path),
+				statusCode: r.StatusCode,
+				size:       info.Size(),
+				storedAt:   r.StoredAt(),
+				expiry:     r.ExpiresAt(),
+			},
+			modTime: info.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// most recently used first
+	slices.SortFunc(files, func(a, b found) int {
+		return b.modTime.Compare(a.modTime)
+	})
+
+	for _, f := range files {
+		if old, ok := s.index[f.entry.key]; ok {
+			if !f.entry.storedAt.After(old.storedAt) {
+				_ = os.Remove(s.path(f.entry.hash))
+
+				continue
+			}
+
+			s.removeLocked(old)
+		}
+
+		if s.used+f.entry.size > s.maxSize {
+			_ = os.Remove(s.path(f.entry.hash))
+
+			continue
+		}
+
+		s.addLocked(f.entry, false)
+	}
+
+	slog.Debug("Rebuilt disk cache index", "records", len(s.index), "bytes", s.used)
+
+	return nil
+}
+
+func encodeDiskFile(key string, r *Record) ([]byte, error) {
+	record, err := r.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(diskFileMagic)+binary.MaxVarintLen64+len(key)+len(record))
+	b = append(b, diskFileMagic...)
+	b = binary.AppendUvarint(b, uint64(len(key)))
+	b = append(b, key...)
+
+	return append(b, record...), nil
+}
+
+func decodeDiskFile(data []byte) (string, *Record, error) {
+	if !strings.HasPrefix(string(data[:min(len(data), len(diskFileMagic))]), diskFileMagic) {
+		return "", nil, memcache.ErrInvalidRecord
+	}
+
+	data = data[len(diskFileMagic):]
+
+	n, l := binary.Uvarint(data)
+	if l <= 0 || n > uint64(len(data)-l) {
+		return "", nil, memcache.ErrInvalidRecord
+	}
+
+	key := string(data[l : l+int(n)])
+
+	var r Record
+	if err := r.UnmarshalBinary(data[l+int(n):]); err != nil {
+		return "", nil, err
+	}
+
+	return key, &r, nil
+}
+
+func readDiskFile(path string) (string, *Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return decodeDiskFile(data)
+}
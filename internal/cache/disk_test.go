@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+	"github.com/matryer/is"
+)
+
+func TestDiskStore(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	s, err := NewDiskStore(t.TempDir(), 1024, 512)
+	eval.NoErr(err)
+
+	_, err = s.Get(ctx, "k")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	eval.NoErr(s.Set(ctx, "k", &Record{StatusCode: 200, Body: []byte("body")}, time.Minute))
+
+	r, err := s.Get(ctx, "k")
+	eval.NoErr(err)
+	eval.Equal(r.StatusCode, 200)
+	eval.Equal(r.Body, []byte("body"))
+
+	err = s.Set(ctx, "large", &Record{Body: make([]byte, 600)}, time.Minute)
+	eval.Equal(err, memcache.ErrMaxRecordSizeExceed)
+
+	eval.NoErr(s.Set(ctx, "short", &Record{}, 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = s.Get(ctx, "short")
+	eval.True(errors.Is(err, ErrExpired))
+
+	eval.NoErr(s.Delete(ctx, "k"))
+
+	stats, err := s.Stats(ctx)
+	eval.NoErr(err)
+	eval.Equal(stats.Hits, int64(1))
+	eval.Equal(stats.Misses, int64(2))
+	eval.Equal(stats.RejectedTooLarge, int64(1))
+	eval.Equal(stats.Evictions.Expired, int64(1))
+	eval.Equal(stats.Evictions.Purged, int64(1))
+	eval.Equal(stats.Records, 0)
+	eval.Equal(stats.BytesUsed, 0)
+}
+
+func TestDiskStoreEviction(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	s, err := NewDiskStore(t.TempDir(), 1000, 1000)
+	eval.NoErr(err)
+
+	for _, k := range []string{"a", "b", "c"} {
+		eval.NoErr(s.Set(ctx, k, &Record{Body: make([]byte, 300)}, time.Minute))
+	}
+
+	// "a" becomes the most recently used record, "b" is evicted
+	_, err = s.Get(ctx, "a")
+	eval.NoErr(err)
+
+	eval.NoErr(s.Set(ctx, "d", &Record{Body: make([]byte, 300)}, time.Minute))
+
+	_, err = s.Get(ctx, "b")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	for _, k := range []string{"a", "c", "d"} {
+		_, err = s.Get(ctx, k)
+		eval.NoErr(err)
+	}
+
+	stats, err := s.Stats(ctx)
+	eval.NoErr(err)
+	eval.Equal(stats.Evictions.Capacity, int64(1))
+	eval.True(stats.BytesUsed <= 1000)
+}
+
+func TestDiskStoreRebuildIndex(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := NewDiskStore(dir, 1024, 1024)
+	eval.NoErr(err)
+
+	eval.NoErr(s.Set(ctx, "k1", &Record{StatusCode: 200, Body: []byte("one")}, time.Minute))
+	eval.NoErr(s.Set(ctx, "k2", &Record{StatusCode: 200, Body: []byte("two")}, time.Minute))
+	eval.NoErr(s.Set(ctx, "k2", &Record{StatusCode: 200, Body: []byte("three")}, time.Minute))
+	eval.NoErr(s.Set(ctx, "expired", &Record{}, time.Millisecond))
+
+	// corrupt the file of k1 and leave a partial write behind
+	path := s.path(s.index["k1"].hash)
+	eval.NoErr(os.WriteFile(path, []byte("garbage"), 0o644))
+	eval.NoErr(os.WriteFile(filepath.Join(dir, diskTmpDir, "partial"), []byte("x"), 0o644))
+
+	// files the store didn't write are left alone, wherever they are
+	foreign := []string{
+		filepath.Join(dir, "README"),
+		filepath.Join(dir, filepath.Base(filepath.Dir(path)), "notes.txt"),
+		filepath.Join(dir, "other", filepath.Base(path)),
+	}
+	for _, f := range foreign {
+		eval.NoErr(os.MkdirAll(filepath.Dir(f), 0o755))
+		eval.NoErr(os.WriteFile(f, []byte("keep"), 0o644))
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	s, err = NewDiskStore(dir, 1024, 1024)
+	eval.NoErr(err)
+
+	_, err = s.Get(ctx, "k1")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	r, err := s.Get(ctx, "k2")
+	eval.NoErr(err)
+	eval.Equal(r.Body, []byte("three"))
+
+	stats, err := s.Stats(ctx)
+	eval.NoErr(err)
+	eval.Equal(stats.Records, 1)
+
+	_, err = os.Stat(path)
+	eval.True(errors.Is(err, os.ErrNotExist))
+
+	tmp, err := os.ReadDir(filepath.Join(dir, diskTmpDir))
+	eval.NoErr(err)
+	eval.Equal(len(tmp), 0)
+
+	for _, f := range foreign {
+		data, err := os.ReadFile(f)
+		eval.NoErr(err)
+		eval.Equal(string(data), "keep")
+	}
+}
+
+func TestTieredStore(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	l1 := NewMemoryStore(memcache.NewMemoryCache(time.Minute, 4096, 400))
+
+	l2, err := NewDiskStore(t.TempDir(), 4096, 2048)
+	eval.NoErr(err)
+
+	s := NewTieredStore(l1, l2)
+
+	// too large for memory, kept on disk only
+	eval.NoErr(s.Set(ctx, "large", &Record{Body: make([]byte, 500)}, time.Minute))
+	eval.NoErr(s.Set(ctx, "small", &Record{Body: []byte("small")}, time.Minute))
+
+	_, err = l1.Get(ctx, "large")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	_, err = s.Get(ctx, "large")
+	eval.NoErr(err)
+
+	// promoted from disk once it fits in memory
+	eval.NoErr(l2.Set(ctx, "disk-only", &Record{Body: []byte("disk")}, time.Minute))
+
+	_, err = s.Get(ctx, "disk-only")
+	eval.NoErr(err)
+
+	r, err := l1.Get(ctx, "disk-only")
+	eval.NoErr(err)
+	eval.Equal(r.Body, []byte("disk"))
+	eval.True(r.TTL(time.Now()) > 50*time.Second)
+
+	eval.NoErr(s.Delete(ctx, "small"))
+
+	_, err = s.Get(ctx, "small")
+	eval.True(errors.Is(err, ErrNotFound))
+}
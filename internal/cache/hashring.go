@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"hash/crc32"
+	"slices"
+	"strconv"
+)
+
+const hashRingReplicas = 160
+
+// hashRing maps keys to nodes by consistent hashing, so that adding or
+// removing a node only moves the keys owned by that node.
+type hashRing struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+func newHashRing(nodes []string) *hashRing {
+	r := &hashRing{
+		nodes: make(map[uint32]string, len(nodes)*hashRingReplicas),
+	}
+
+	for _, n := range nodes {
+		for i := range hashRingReplicas {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + n))
+
+			r.hashes = append(r.hashes, h)
+			r.nodes[h] = n
+		}
+	}
+
+	slices.Sort(r.hashes)
+
+	return r
+}
+
+// Node returns the node owning key, or an empty string if the ring is empty.
+func (r *hashRing) Node(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+
+	i, _ := slices.BinarySearch(r.hashes, h)
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.nodes[r.hashes[i]]
+}
@@ -0,0 +1,590 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+const (
+	BackendMemcached = "memcached"
+
+	memcachedFlagRecord  = 0
+	memcachedFlagChunked = 1
+
+	// exptime values above 30 days are taken by memcached as a unix timestamp.
+	memcachedMaxRelativeExptime = 30 * 24 * time.Hour
+
+	// memcachedMaxValueSize bounds values when records aren't, as the
+	// largest item size memcached can be configured with does.
+	memcachedMaxValueSize = 1024 * 1024 * 1024
+)
+
+var errMemcachedNotFound = errors.New("memcached: not found")
+
+// MemcachedOptions configures a MemcachedStore.
+type MemcachedOptions struct {
+	Servers   []string
+	KeyPrefix string
+
+	PoolSize int
+	// MaxItemSize is the largest value stored as a single item. Larger
+	// records are split into chunks of this size.
+	MaxItemSize   int
+	MaxRecordSize int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	RetryBackoff time.Duration
+}
+
+// MemcachedStore keeps records in one or more servers speaking the memcached
+// text protocol. Keys are spread over the servers by consistent hashing.
+//
+// Records larger than MaxItemSize are stored as a manifest item under the key
+// and chunk items, each placed on the server owning the chunk key. Chunk keys
+// include the time the record was stored, so a reader never mixes chunks of
+// two versions; replaced chunks are left to expire on the server.
+//
+// Like RedisStore, the store fails open per server.
+type MemcachedStore struct {
+	opts    MemcachedOptions
+	ring    *hashRing
+	servers map[string]*memcachedServer
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+type memcachedServer struct {
+	addr string
+	pool *connPool
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+type memcachedItem struct {
+	flags uint32
+	value []byte
+}
+
+// memcachedManifest describes a chunked record.
+type memcachedManifest struct {
+	version string
+	chunks  int
+	size    int
+	crc     uint32
+}
+
+func NewMemcachedStore(opts MemcachedOptions) *MemcachedStore {
+	s := &MemcachedStore{
+		opts:    opts,
+		ring:    newHashRing(opts.Servers),
+		servers: make(map[string]*memcachedServer, len(opts.Servers)),
+	}
+
+	for _, addr := range opts.Servers {
+		s.servers[addr] = &memcachedServer{
+			addr: addr,
+			pool: newConnPool(opts.PoolSize, func(ctx context.Context) (*bufConn, error) {
+				return dialConn(ctx, addr, opts.DialTimeout)
+			}),
+		}
+	}
+
+	return s
+}
+
+func (s *MemcachedStore) Get(ctx context.Context, key string) (*Record, error) {
+	data, err := s.getRecordData(ctx, key)
+	if err != nil {
+		s.count(func(stats *Stats) { stats.Misses++ })
+
+		if errors.Is(err, errMemcachedNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var r Record
+	if err := r.UnmarshalBinary(data); err != nil {
+		slog.Error("failed to decode cached record", "key", key, "error", err)
+		s.count(func(stats *Stats) { stats.Misses++ })
+
+		return nil, ErrNotFound
+	}
+
+	if r.Expired(time.Now()) {
+		s.count(func(stats *Stats) {
+			stats.Misses++
+			stats.StaleHits++
+		})
+
+		return nil, ErrExpired
+	}
+
+	s.count(func(stats *Stats) { stats.Hits++ })
+
+	return &r, nil
+}
+
+func (s *MemcachedStore) Set(ctx context.Context, key string, r *Record, ttl time.Duration) error {
+	r.Stamp(time.Now(), ttl)
+
+	data, err := r.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if s.opts.MaxRecordSize > 0 && len(data) > s.opts.MaxRecordSize {
+		s.count(func(stats *Stats) { stats.RejectedTooLarge++ })
+
+		return memcache.ErrMaxRecordSizeExceed
+	}
+
+	exptime := memcachedExptime(ttl)
+	itemKey := s.itemKey(key)
+
+	if len(data) <= s.opts.MaxItemSize {
+		return s.set(ctx, itemKey, memcachedFlagRecord, exptime, data)
+	}
+
+	m := memcachedManifest{
+		version: strconv.FormatInt(r.StoredAt().UnixNano(), 36),
+		chunks:  (len(data) + s.opts.MaxItemSize - 1) / s.opts.MaxItemSize,
+		size:    len(data),
+		crc:     crc32.ChecksumIEEE(data),
+	}
+
+	for i := range m.chunks {
+		chunk := data[i*s.opts.MaxItemSize : min((i+1)*s.opts.MaxItemSize, len(data))]
+
+		if err := s.set(ctx, m.chunkKey(itemKey, i), memcachedFlagRecord, exptime, chunk); err != nil {
+			return err
+		}
+	}
+
+	// The manifest is written last so that readers never see a partial record.
+	return s.set(ctx, itemKey, memcachedFlagChunked, exptime, []byte(m.String()))
+}
+
+func (s *MemcachedStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Remove(ctx, key)
+
+	return err
+}
+
+func (s *MemcachedStore) Remove(ctx context.Context, key string) (bool, error) {
+	itemKey := s.itemKey(key)
+
+	var deleted bool
+
+	err := s.withConn(ctx, itemKey, func(c *bufConn) error {
+		reply, err := c.command("delete " + itemKey)
+		if err != nil {
+			return err
+		}
+
+		switch reply {
+		case "DELETED":
+			deleted = true
+		case "NOT_FOUND":
+		default:
+			return fmt.Errorf("memcached: unexpected reply %q", reply)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if deleted {
+		s.count(func(stats *Stats) { stats.Evictions.Purged++ })
+	}
+
+	return deleted, nil
+}
+
+// Touch updates the expiration time of the record stored under key, including
+// all of its chunks.
+func (s *MemcachedStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	itemKey := s.itemKey(key)
+	exptime := memcachedExptime(ttl)
+
+	items, err := s.getMulti(ctx, []string{itemKey})
+	if err != nil {
+		return err
+	}
+
+	item, ok := items[itemKey]
+	if !ok {
+		return ErrNotFound
+	}
+
+	keys := []string{itemKey}
+
+	if item.flags == memcachedFlagChunked {
+		m, err := parseMemcachedManifest(item.value)
+		if err != nil {
+			return err
+		}
+
+		keys = m.chunkKeys(itemKey)
+		keys = append(keys, itemKey)
+	}
+
+	for _, k := range keys {
+		err := s.withConn(ctx, k, func(c *bufConn) error {
+			reply, err := c.command("touch " + k + " " + strconv.FormatInt(exptime, 10))
+			if err != nil {
+				return err
+			}
+
+			switch reply {
+			case "TOUCHED":
+				return nil
+			case "NOT_FOUND":
+				return ErrNotFound
+			default:
+				return fmt.Errorf("memcached: unexpected reply %q", reply)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the counters of this replica only; the servers are shared.
+func (s *MemcachedStore) Stats(_ context.Context) (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats, nil
+}
+
+func (s *MemcachedStore) Close() error {
+	var errs []error
+
+	for _, srv := range s.servers {
+		errs = append(errs, srv.pool.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *MemcachedStore) count(f func(stats *Stats)) {
+	s.mu.Lock()
+	f(&s.stats)
+	s.mu.Unlock()
+}
+
+// maxRecordSize returns the size of the largest value read from a server,
+// which is checked before anything is allocated for it.
+func (s *MemcachedStore) maxRecordSize() int {
+	if s.opts.MaxRecordSize > 0 {
+		return s.opts.MaxRecordSize
+	}
+
+	return memcachedMaxValueSize
+}
+
+// itemKey maps a cache key to a memcached key, which is limited to 250
+// bytes without spaces or control characters.
+func (s *MemcachedStore) itemKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return s.opts.KeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (s *MemcachedStore) getRecordData(ctx context.Context, key string) ([]byte, error) {
+	itemKey := s.itemKey(key)
+
+	items, err := s.getMulti(ctx, []string{itemKey})
+	if err != nil {
+		return nil, err
+	}
+
+	item, ok := items[itemKey]
+	if !ok {
+		return nil, errMemcachedNotFound
+	}
+
+	if item.flags != memcachedFlagChunked {
+		return item.value, nil
+	}
+
+	m, err := parseMemcachedManifest(item.value)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.size > s.maxRecordSize() {
+		return nil, memcache.ErrInvalidRecord
+	}
+
+	chunkKeys := m.chunkKeys(itemKey)
+
+	chunks, err := s.getMulti(ctx, chunkKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, m.size)
+
+	for _, k := range chunkKeys {
+		chunk, ok := chunks[k]
+		if !ok {
+			return nil, errMemcachedNotFound
+		}
+
+		data = append(data, chunk.value...)
+	}
+
+	if len(data) != m.size || crc32.ChecksumIEEE(data) != m.crc {
+		return nil, memcache.ErrInvalidRecord
+	}
+
+	return data, nil
+}
+
+// getMulti fetches keys with one get command per server.
+func (s *MemcachedStore) getMulti(ctx context.Context, keys []string) (map[string]memcachedItem, error) {
+	byServer := make(map[string][]string)
+	for _, k := range keys {
+		addr := s.ring.Node(k)
+		byServer[addr] = append(byServer[addr], k)
+	}
+
+	items := make(map[string]memcachedItem, len(keys))
+
+	for _, serverKeys := range byServer {
+		err := s.withConn(ctx, serverKeys[0], func(c *bufConn) error {
+			if err := c.writeLine("get " + strings.Join(serverKeys, " ")); err != nil {
+				return err
+			}
+
+			for {
+				line, err := c.readLine()
+				if err != nil {
+					return err
+				}
+
+				if line == "END" {
+					return nil
+				}
+
+				// VALUE <key> <flags> <bytes> [<cas unique>]
+				fields := strings.Fields(line)
+				if len(fields) < 4 || fields[0] != "VALUE" {
+					return fmt.Errorf("memcached: unexpected reply %q", line)
+				}
+
+				flags, err := strconv.ParseUint(fields[2], 10, 32)
+				if err != nil {
+					return err
+				}
+
+				n, err := strconv.Atoi(fields[3])
+				if err != nil {
+					return err
+				}
+
+				if n < 0 || n > s.maxRecordSize() {
+					return fmt.Errorf("memcached: value of %d bytes exceeds the limit of %d", n, s.maxRecordSize())
+				}
+
+				buf := make([]byte, n+2)
+				if _, err := io.ReadFull(c.r, buf); err != nil {
+					return err
+				}
+
+				items[fields[1]] = memcachedItem{flags: uint32(flags), value: buf[:n]}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+func (s *MemcachedStore) set(ctx context.Context, itemKey string, flags uint32, exptime int64, data []byte) error {
+	return s.withConn(ctx, itemKey, func(c *bufConn) error {
+		fmt.Fprintf(c.w, "set %s %d %d %d\r\n", itemKey, flags, exptime, len(data))
+
+		if _, err := c.w.Write(data); err != nil {
+			return err
+		}
+
+		if err := c.writeLine(""); err != nil {
+			return err
+		}
+
+		reply, err := c.readLine()
+		if err != nil {
+			return err
+		}
+
+		if reply != "STORED" {
+			return fmt.Errorf("memcached: unexpected reply %q", reply)
+		}
+
+		return nil
+	})
+}
+
+// withConn runs f on a pooled connection to the server owning itemKey.
+// Connection errors mark the server as unavailable for RetryBackoff.
+func (s *MemcachedStore) withConn(ctx context.Context, itemKey string, f func(c *bufConn) error) error {
+	srv, ok := s.servers[s.ring.Node(itemKey)]
+	if !ok {
+		return ErrUnavailable
+	}
+
+	srv.mu.Lock()
+	down := time.Now().Before(srv.downUntil)
+	srv.mu.Unlock()
+
+	if down {
+		return ErrUnavailable
+	}
+
+	c, err := srv.pool.Get(ctx)
+	if err != nil {
+		// A client giving up says nothing about the server.
+		if ctx.Err() == nil {
+			s.markDown(srv, err)
+		}
+
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	if s.opts.WriteTimeout > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(s.opts.WriteTimeout))
+	}
+
+	if s.opts.ReadTimeout > 0 {
+		_ = c.SetReadDeadline(time.Now().Add(s.opts.ReadTimeout))
+	}
+
+	err = f(c)
+
+	var replyErr memcachedError
+	if err != nil && !errors.As(err, &replyErr) && !errors.Is(err, ErrNotFound) {
+		_ = c.Close()
+		srv.pool.Put(nil)
+		s.markDown(srv, err)
+
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	srv.pool.Put(c)
+
+	return err
+}
+
+func (s *MemcachedStore) markDown(srv *memcachedServer, err error) {
+	slog.Error("cache server unavailable, failing open", "addr", srv.addr, "error", err)
+
+	srv.mu.Lock()
+	srv.downUntil = time.Now().Add(s.opts.RetryBackoff)
+	srv.mu.Unlock()
+}
+
+func memcachedExptime(ttl time.Duration) int64 {
+	if ttl > memcachedMaxRelativeExptime {
+		return time.Now().Add(ttl).Unix()
+	}
+
+	return max(int64((ttl+time.Second-1)/time.Second), 1)
+}
+
+func (m memcachedManifest) String() string {
+	return fmt.Sprintf("%s %d %d %d", m.version, m.chunks, m.size, m.crc)
+}
+
+func (m memcachedManifest) chunkKey(itemKey string, i int) string {
+	return itemKey + ":" + m.version + ":" + strconv.Itoa(i)
+}
+
+func (m memcachedManifest) chunkKeys(itemKey string) []string {
+	keys := make([]string, 0, m.chunks)
+	for i := range m.chunks {
+		keys = append(keys, m.chunkKey(itemKey, i))
+	}
+
+	return keys
+}
+
+func parseMemcachedManifest(b []byte) (memcachedManifest, error) {
+	var m memcachedManifest
+
+	if _, err := fmt.Sscanf(string(b), "%s %d %d %d", &m.version, &m.chunks, &m.size, &m.crc); err != nil {
+		return m, memcache.ErrInvalidRecord
+	}
+
+	if m.chunks < 1 || m.chunks > m.size {
+		return m, memcache.ErrInvalidRecord
+	}
+
+	return m, nil
+}
+
+// memcachedError is an ERROR, CLIENT_ERROR or SERVER_ERROR reply, after
+// which the connection remains usable.
+type memcachedError string
+
+func (e memcachedError) Error() string {
+	return "memcached: " + string(e)
+}
+
+func (c *bufConn) writeLine(line string) error {
+	if _, err := c.w.WriteString(line + "\r\n"); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+func (c *bufConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", memcachedError(line)
+	}
+
+	return line, nil
+}
+
+// command writes line and returns the single line reply.
+func (c *bufConn) command(line string) (string, error) {
+	if err := c.writeLine(line); err != nil {
+		return "", err
+	}
+
+	return c.readLine()
+}
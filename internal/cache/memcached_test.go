@@ -0,0 +1,392 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+	"github.com/matryer/is"
+)
+
+// fakeMemcached is a minimal in-process server implementing the memcached
+// text protocol commands used by MemcachedStore.
+type fakeMemcached struct {
+	ln          net.Listener
+	maxItemSize int
+
+	mu    sync.Mutex
+	items map[string]fakeMemcachedItem
+	open  []net.Conn
+}
+
+type fakeMemcachedItem struct {
+	flags   uint32
+	value   []byte
+	exptime time.Time
+}
+
+func newFakeMemcached(t *testing.T, maxItemSize int) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeMemcached{
+		ln:          ln,
+		maxItemSize: maxItemSize,
+		items:       make(map[string]fakeMemcachedItem),
+	}
+
+	go f.serve()
+
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeMemcached) Addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *fakeMemcached) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.items)
+}
+
+func (f *fakeMemcached) Close() {
+	_ = f.ln.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.open {
+		_ = c.Close()
+	}
+}
+
+func (f *fakeMemcached) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.open = append(f.open, conn)
+		f.mu.Unlock()
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeMemcached) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		var reply string
+
+		switch fields[0] {
+		case "get":
+			var b strings.Builder
+			for _, k := range fields[1:] {
+				if it, ok := f.get(k); ok {
+					fmt.Fprintf(&b, "VALUE %s %d %d\r\n%s\r\n", k, it.flags, len(it.value), it.value)
+				}
+			}
+
+			reply = b.String() + "END\r\n"
+		case "set":
+			flags, _ := strconv.ParseUint(fields[2], 10, 32)
+			exptime, _ := strconv.Atoi(fields[3])
+			n, _ := strconv.Atoi(fields[4])
+
+			buf := make([]byte, n+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+
+			if n > f.maxItemSize {
+				reply = "SERVER_ERROR object too large for cache\r\n"
+
+				break
+			}
+
+			f.mu.Lock()
+			f.items[fields[1]] = fakeMemcachedItem{
+				flags:   uint32(flags),
+				value:   buf[:n],
+				exptime: time.Now().Add(time.Duration(exptime) * time.Second),
+			}
+			f.mu.Unlock()
+
+			reply = "STORED\r\n"
+		case "delete":
+			_, ok := f.get(fields[1])
+
+			f.mu.Lock()
+			delete(f.items, fields[1])
+			f.mu.Unlock()
+
+			reply = "NOT_FOUND\r\n"
+			if ok {
+				reply = "DELETED\r\n"
+			}
+		case "touch":
+			exptime, _ := strconv.Atoi(fields[2])
+
+			reply = "NOT_FOUND\r\n"
+
+			if it, ok := f.get(fields[1]); ok {
+				it.exptime = time.Now().Add(time.Duration(exptime) * time.Second)
+
+				f.mu.Lock()
+				f.items[fields[1]] = it
+				f.mu.Unlock()
+
+				reply = "TOUCHED\r\n"
+			}
+		default:
+			reply = "ERROR\r\n"
+		}
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeMemcached) get(k string) (fakeMemcachedItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	it, ok := f.items[k]
+	if ok && time.Now().After(it.exptime) {
+		delete(f.items, k)
+
+		return it, false
+	}
+
+	return it, ok
+}
+
+func newTestMemcachedStore(servers ...string) *MemcachedStore {
+	return NewMemcachedStore(MemcachedOptions{
+		Servers:       servers,
+		KeyPrefix:     "test:",
+		PoolSize:      2,
+		MaxItemSize:   100,
+		MaxRecordSize: 4096,
+		DialTimeout:   100 * time.Millisecond,
+		ReadTimeout:   100 * time.Millisecond,
+		WriteTimeout:  100 * time.Millisecond,
+		RetryBackoff:  time.Minute,
+	})
+}
+
+func TestMemcachedStore(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	srv := newFakeMemcached(t, 100)
+
+	s := newTestMemcachedStore(srv.Addr())
+	defer func() { _ = s.Close() }()
+
+	_, err := s.Get(ctx, "k")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	eval.NoErr(s.Set(ctx, "GET:/path with spaces", &Record{StatusCode: 200, Body: []byte("body")}, time.Minute))
+
+	r, err := s.Get(ctx, "GET:/path with spaces")
+	eval.NoErr(err)
+	eval.Equal(r.Body, []byte("body"))
+
+	err = s.Set(ctx, "large", &Record{Body: make([]byte, 5000)}, time.Minute)
+	eval.Equal(err, memcache.ErrMaxRecordSizeExceed)
+
+	eval.NoErr(s.Delete(ctx, "GET:/path with spaces"))
+
+	_, err = s.Get(ctx, "GET:/path with spaces")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	stats, err := s.Stats(ctx)
+	eval.NoErr(err)
+	eval.Equal(stats.Hits, int64(1))
+	eval.Equal(stats.Misses, int64(2))
+	eval.Equal(stats.RejectedTooLarge, int64(1))
+	eval.Equal(stats.Evictions.Purged, int64(1))
+}
+
+func TestMemcachedStoreChunking(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	srv1 := newFakeMemcached(t, 100)
+	srv2 := newFakeMemcached(t, 100)
+
+	s := newTestMemcachedStore(srv1.Addr(), srv2.Addr())
+	defer func() { _ = s.Close() }()
+
+	body := []byte(strings.Repeat("0123456789", 100))
+
+	eval.NoErr(s.Set(ctx, "k", &Record{StatusCode: 200, Body: body}, time.Minute))
+
+	// manifest plus 11 chunks spread over both servers
+	eval.Equal(srv1.Len()+srv2.Len(), 12)
+	eval.True(srv1.Len() > 0 && srv2.Len() > 0)
+
+	r, err := s.Get(ctx, "k")
+	eval.NoErr(err)
+	eval.Equal(r.Body, body)
+
+	eval.NoErr(s.Touch(ctx, "k", time.Hour))
+	eval.Equal(s.Touch(ctx, "missing", time.Hour), ErrNotFound)
+
+	// a missing chunk turns the record into a miss
+	itemKey := s.itemKey("k")
+	for _, f := range []*fakeMemcached{srv1, srv2} {
+		f.mu.Lock()
+		for k := range f.items {
+			if strings.HasPrefix(k, itemKey+":") && strings.HasSuffix(k, ":3") {
+				delete(f.items, k)
+			}
+		}
+		f.mu.Unlock()
+	}
+
+	_, err = s.Get(ctx, "k")
+	eval.True(errors.Is(err, ErrNotFound))
+}
+
+func TestMemcachedStoreOversizedValues(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	srv := newFakeMemcached(t, 100)
+
+	s := newTestMemcachedStore(srv.Addr())
+	defer func() { _ = s.Close() }()
+
+	put := func(key string, flags uint32, value []byte) {
+		srv.mu.Lock()
+		srv.items[s.itemKey(key)] = fakeMemcachedItem{flags: flags, value: value, exptime: time.Now().Add(time.Minute)}
+		srv.mu.Unlock()
+	}
+
+	// manifests claiming more than a record may hold are misses
+	for i, manifest := range []string{"v 3 1000000000000 0", "v -1 -1 0", "v 100 10 0"} {
+		key := "manifest" + strconv.Itoa(i)
+		put(key, memcachedFlagChunked, []byte(manifest))
+
+		_, err := s.Get(ctx, key)
+		eval.True(errors.Is(err, ErrNotFound))
+	}
+
+	// a value larger than a record may be isn't read
+	put("large", memcachedFlagRecord, make([]byte, 5000))
+
+	_, err := s.Get(ctx, "large")
+	eval.True(errors.Is(err, ErrNotFound))
+	eval.True(errors.Is(err, ErrUnavailable))
+}
+
+func TestMemcachedStoreCancelledContext(t *testing.T) {
+	eval := is.New(t)
+
+	srv := newFakeMemcached(t, 100)
+
+	s := newTestMemcachedStore(srv.Addr())
+	defer func() { _ = s.Close() }()
+
+	eval.NoErr(s.Set(context.Background(), "k", &Record{}, time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for range 10 {
+		_, _ = s.Get(ctx, "k")
+	}
+
+	// The server isn't considered down because of an impatient client.
+	_, err := s.Get(context.Background(), "k")
+	eval.NoErr(err)
+}
+
+func TestMemcachedStoreFailOpen(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	srv1 := newFakeMemcached(t, 100)
+	srv2 := newFakeMemcached(t, 100)
+
+	s := newTestMemcachedStore(srv1.Addr(), srv2.Addr())
+	defer func() { _ = s.Close() }()
+
+	keys := make([]string, 0, 20)
+	for i := range 20 {
+		keys = append(keys, "k"+strconv.Itoa(i))
+		eval.NoErr(s.Set(ctx, keys[i], &Record{}, time.Minute))
+	}
+
+	srv2.Close()
+
+	var hits, misses int
+	for _, k := range keys {
+		if _, err := s.Get(ctx, k); err == nil {
+			hits++
+		} else {
+			eval.True(errors.Is(err, ErrNotFound))
+			misses++
+		}
+	}
+
+	// keys owned by the remaining server are still served
+	eval.Equal(hits, srv1.Len())
+	eval.True(misses > 0)
+}
+
+func TestHashRing(t *testing.T) {
+	eval := is.New(t)
+
+	r := newHashRing([]string{"a", "b", "c"})
+
+	owners := make(map[string]string)
+	for i := range 1000 {
+		k := strconv.Itoa(i)
+		owners[k] = r.Node(k)
+	}
+
+	// removing a node only moves the keys it owned
+	r = newHashRing([]string{"a", "b"})
+
+	for k, owner := range owners {
+		if owner != "c" {
+			eval.Equal(r.Node(k), owner)
+		}
+	}
+
+	eval.Equal(newHashRing(nil).Node("k"), "")
+}
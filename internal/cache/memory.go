@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+// memoryCache is implemented by memcache.MemoryCache and memcache.ShardedMemoryCache.
+type memoryCache interface {
+	Lookup(k string) (*memcache.Record, bool)
+	SetWithTTL(k string, data *memcache.Record, ttl time.Duration) error
+	Restore(k string, data *memcache.Record) error
+	Delete(k string) bool
+	Purge() int
+	Stats() memcache.Stats
+	Entries(f memcache.EntryFilter) ([]memcache.EntryInfo, int)
+	Snapshot() []memcache.SnapshotEntry
+	Close() error
+}
+
+// MemoryStore adapts the in-memory caches of the memcache package to the Store interface.
+type MemoryStore struct {
+	cache memoryCache
+
+	// snapshotPath is where a snapshot is saved on Close, if set.
+	snapshotPath string
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+func NewMemoryStore(cache memoryCache) *MemoryStore {
+	return &MemoryStore{cache: cache}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (*Record, error) {
+	r, stale := s.cache.Lookup(key)
+	if stale {
+		return nil, ErrExpired
+	}
+
+	if r == nil {
+		return nil, ErrNotFound
+	}
+
+	return r, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, r *Record, ttl time.Duration) error {
+	return s.cache.SetWithTTL(key, r, ttl)
+}
+
+// Restore stores r keeping the stored and expiry times it already carries.
+func (s *MemoryStore) Restore(key string, r *Record) error {
+	return s.cache.Restore(key, r)
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Remove(ctx, key)
+
+	return err
+}
+
+func (s *MemoryStore) Remove(_ context.Context, key string) (bool, error) {
+	return s.cache.Delete(key), nil
+}
+
+func (s *MemoryStore) Stats(_ context.Context) (Stats, error) {
+	return s.cache.Stats(), nil
+}
+
+func (s *MemoryStore) Entries(_ context.Context, f memcache.EntryFilter) ([]memcache.EntryInfo, int, error) {
+	entries, total := s.cache.Entries(f)
+
+	return entries, total, nil
+}
+
+func (s *MemoryStore) Purge(_ context.Context) (int, error) {
+	return s.cache.Purge(), nil
+}
+
+// Close stops the periodic snapshots and saves a last one, if started, then
+// closes the cache.
+func (s *MemoryStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+
+	var err error
+	if s.snapshotPath != "" {
+		err = s.SaveSnapshot(s.snapshotPath)
+		s.snapshotPath = ""
+	}
+
+	return errors.Join(err, s.cache.Close())
+}
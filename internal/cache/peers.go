@@ -0,0 +1,516 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+const (
+	BackendPeers = "peers"
+
+	peerRecordsPath = "/records"
+
+	// maxRemoteHits bounds the keys whose fetches from other replicas are
+	// counted. The counts are reset when it is reached, so that keys
+	// fetched once don't accumulate.
+	maxRemoteHits = 10000
+)
+
+// PeerOptions configures a PeerStore.
+type PeerOptions struct {
+	// Self is the base URL under which the other replicas reach this one.
+	Self string
+	// Peers is the static list of peer base URLs, including Self.
+	Peers []string
+	// PeersFile, when set, is read every RefreshInterval for the peer list,
+	// one base URL per line. It replaces Peers.
+	PeersFile       string
+	RefreshInterval time.Duration
+
+	// A record owned by another replica is mirrored locally for HotTTL once it
+	// has been fetched from its owner HotThreshold times.
+	HotThreshold int
+	HotTTL       time.Duration
+
+	Timeout time.Duration
+	// RetryBackoff is how long a replica that couldn't be reached is
+	// considered down, its keys missing without contacting it.
+	RetryBackoff time.Duration
+
+	// Secret authenticates the replicas to each other. Requests to Handler
+	// without it are refused, so that no one else can read, poison or purge
+	// the cache.
+	Secret string
+}
+
+// PeerStore shares the cache between proxy replicas without an external
+// store. Every key is owned by one replica chosen by consistent hashing over
+// the peer list. Owners keep their records in a local MemoryStore, other
+// replicas fetch and store them over HTTP, and keep mirrors of hot keys.
+//
+// Deleting a key removes it from its owner and this replica's mirror only;
+// mirrors on other replicas expire after HotTTL.
+type PeerStore struct {
+	opts   PeerOptions
+	local  *MemoryStore
+	hot    *MemoryStore
+	client *http.Client
+
+	mu         sync.RWMutex
+	peers      []string
+	ring       *hashRing
+	remoteHits map[string]int
+	// maxRemoteHits is the constant of the same name, lowered by tests.
+	maxRemoteHits int
+	stats         Stats
+	// downUntil holds the replicas that couldn't be reached, until when
+	// they aren't contacted.
+	downUntil map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewPeerStore(opts PeerOptions, local, hot *MemoryStore) (*PeerStore, error) {
+	if opts.Secret == "" {
+		return nil, errors.New("peer cache requires a shared secret")
+	}
+
+	opts.Self = strings.TrimRight(opts.Self, "/")
+
+	s := &PeerStore{
+		opts:       opts,
+		local:      local,
+		hot:        hot,
+		client:     &http.Client{Timeout: opts.Timeout},
+		remoteHits: make(map[string]int),
+		downUntil:  make(map[string]time.Time),
+
+		maxRemoteHits: maxRemoteHits,
+	}
+
+	peers := opts.Peers
+	if opts.PeersFile != "" {
+		var err error
+		if peers, err = readPeersFile(opts.PeersFile); err != nil {
+			return nil, err
+		}
+
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+
+		go s.watchPeersFile()
+	}
+
+	s.SetPeers(peers)
+
+	return s, nil
+}
+
+// SetPeers replaces the peer list. This replica is always part of it.
+func (s *PeerStore) SetPeers(peers []string) {
+	normalized := []string{s.opts.Self}
+	for _, p := range peers {
+		if p = strings.TrimRight(p, "/"); p != "" && !slices.Contains(normalized, p) {
+			normalized = append(normalized, p)
+		}
+	}
+
+	slices.Sort(normalized)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.Equal(normalized, s.peers) {
+		return
+	}
+
+	slog.Info("Updated cache peers", "peers", normalized)
+
+	for peer := range s.downUntil {
+		if !slices.Contains(normalized, peer) {
+			delete(s.downUntil, peer)
+		}
+	}
+
+	s.peers = normalized
+	s.ring = newHashRing(normalized)
+}
+
+func (s *PeerStore) Get(ctx context.Context, key string) (*Record, error) {
+	owner := s.owner(key)
+	if owner == s.opts.Self {
+		return s.local.Get(ctx, key)
+	}
+
+	if r, err := s.hot.Get(ctx, key); err == nil {
+		s.count(func(stats *Stats) { stats.Hits++ })
+
+		return r, nil
+	}
+
+	r, err := s.fetch(ctx, owner, key)
+	if err != nil {
+		s.count(func(stats *Stats) { stats.Misses++ })
+
+		return nil, err
+	}
+
+	s.count(func(stats *Stats) { stats.Hits++ })
+
+	s.mu.Lock()
+	if _, ok := s.remoteHits[key]; !ok && len(s.remoteHits) >= s.maxRemoteHits {
+		clear(s.remoteHits)
+	}
+
+	s.remoteHits[key]++
+	hot := s.remoteHits[key] >= s.opts.HotThreshold
+	if hot {
+		delete(s.remoteHits, key)
+	}
+	s.mu.Unlock()
+
+	if hot {
+		// The mirror keeps the age of the record and expires after HotTTL at the latest.
+		mirror := *r
+		mirror.Stamp(r.StoredAt(), min(r.ExpiresAt().Sub(r.StoredAt()), time.Since(r.StoredAt())+s.opts.HotTTL))
+
+		if err := s.hot.Restore(key, &mirror); err != nil {
+			slog.Debug("Hot record not mirrored", "key", key, "error", err)
+		}
+	}
+
+	return r, nil
+}
+
+func (s *PeerStore) Set(ctx context.Context, key string, r *Record, ttl time.Duration) error {
+	owner := s.owner(key)
+	if owner == s.opts.Self {
+		return s.local.Set(ctx, key, r, ttl)
+	}
+
+	r.Stamp(time.Now(), ttl)
+
+	data, err := r.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, owner, key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusRequestEntityTooLarge:
+		return memcache.ErrMaxRecordSizeExceed
+	default:
+		return fmt.Errorf("peer %s: unexpected status %d", owner, resp.StatusCode)
+	}
+}
+
+func (s *PeerStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Remove(ctx, key)
+
+	return err
+}
+
+// Remove deletes the record from its owner and from the hot copies of this
+// replica, reporting whether either held it.
+func (s *PeerStore) Remove(ctx context.Context, key string) (bool, error) {
+	hot, err := s.hot.Remove(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	owner := s.owner(key)
+	if owner == s.opts.Self {
+		ok, err := s.local.Remove(ctx, key)
+
+		return hot || ok, err
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, owner, key, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return hot, nil
+	default:
+		return false, fmt.Errorf("peer %s: unexpected status %d", owner, resp.StatusCode)
+	}
+}
+
+// Stats returns the counters of the records owned by this replica, plus the
+// hits and misses of lookups answered by mirrors or other replicas.
+func (s *PeerStore) Stats(ctx context.Context) (Stats, error) {
+	stats, err := s.local.Stats(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	s.mu.RLock()
+	stats.Hits += s.stats.Hits
+	stats.Misses += s.stats.Misses
+	s.mu.RUnlock()
+
+	return stats, nil
+}
+
+// Entries lists the records owned by this replica.
+func (s *PeerStore) Entries(ctx context.Context, f memcache.EntryFilter) ([]memcache.EntryInfo, int, error) {
+	return s.local.Entries(ctx, f)
+}
+
+// Purge drops the records owned and mirrored by this replica.
+func (s *PeerStore) Purge(ctx context.Context) (int, error) {
+	if _, err := s.hot.Purge(ctx); err != nil {
+		return 0, err
+	}
+
+	return s.local.Purge(ctx)
+}
+
+func (s *PeerStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+
+	return errors.Join(s.local.Close(), s.hot.Close())
+}
+
+// Handler serves the records owned by this replica to its peers, which
+// authenticate with the shared secret.
+func (s *PeerStore) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET "+peerRecordsPath, func(rw http.ResponseWriter, r *http.Request) {
+		rec, err := s.local.Get(r.Context(), r.URL.Query().Get("key"))
+		if err != nil {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+
+		data, err := rec.MarshalBinary()
+		if err != nil {
+			http.Error(rw, "failed to encode record", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = rw.Write(data)
+	})
+
+	mux.HandleFunc("PUT "+peerRecordsPath, func(rw http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(rw, "failed to read record", http.StatusBadRequest)
+			return
+		}
+
+		var rec Record
+		if err := rec.UnmarshalBinary(data); err != nil {
+			http.Error(rw, "invalid record", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.local.Restore(r.URL.Query().Get("key"), &rec); err != nil {
+			http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE "+peerRecordsPath, func(rw http.ResponseWriter, r *http.Request) {
+		if ok, _ := s.local.Remove(r.Context(), r.URL.Query().Get("key")); !ok {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(rw, r)
+	})
+}
+
+func (s *PeerStore) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Secret)) == 1
+}
+
+func (s *PeerStore) owner(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ring.Node(key)
+}
+
+func (s *PeerStore) count(f func(stats *Stats)) {
+	s.mu.Lock()
+	f(&s.stats)
+	s.mu.Unlock()
+}
+
+// fetch gets key from its owner. Unreachable owners are reported as a miss.
+func (s *PeerStore) fetch(ctx context.Context, owner, key string) (*Record, error) {
+	resp, err := s.do(ctx, http.MethodGet, owner, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrNotFound
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var r Record
+	if err := r.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	return &r, nil
+}
+
+// do sends a request about key to peer. Replicas that can't be reached are
+// marked down for RetryBackoff, failing requests to them right away.
+func (s *PeerStore) do(ctx context.Context, method, peer, key string, body io.Reader) (*http.Response, error) {
+	s.mu.RLock()
+	until, down := s.downUntil[peer]
+	s.mu.RUnlock()
+
+	if down && time.Now().Before(until) {
+		return nil, fmt.Errorf("%w: peer %s is down", ErrUnavailable, peer)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, peer+peerRecordsPath+"?key="+url.QueryEscape(key), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+s.opts.Secret)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// A client giving up says nothing about the peer.
+		if ctx.Err() == nil {
+			s.markDown(peer, err)
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	if down {
+		s.mu.Lock()
+		delete(s.downUntil, peer)
+		s.mu.Unlock()
+
+		slog.Info("Cache peer available again", "peer", peer)
+	}
+
+	return resp, nil
+}
+
+// markDown marks peer down for RetryBackoff, logging once per outage.
+func (s *PeerStore) markDown(peer string, err error) {
+	s.mu.Lock()
+	_, down := s.downUntil[peer]
+	s.downUntil[peer] = time.Now().Add(s.opts.RetryBackoff)
+	s.mu.Unlock()
+
+	if !down {
+		slog.Warn("cache peer unavailable, failing open", "peer", peer, "error", err)
+	}
+}
+
+func (s *PeerStore) watchPeersFile() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opts.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			peers, err := readPeersFile(s.opts.PeersFile)
+			if err != nil {
+				slog.Error("failed to read peers file", "path", s.opts.PeersFile, "error", err)
+
+				continue
+			}
+
+			s.SetPeers(peers)
+		}
+	}
+}
+
+// readPeersFile reads one peer base URL per line, ignoring blank lines and
+// lines starting with #.
+func readPeersFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var peers []string
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		peers = append(peers, line)
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(peers) == 0 {
+		return nil, errors.New("peers file lists no peers")
+	}
+
+	return peers, nil
+}
@@ -0,0 +1,324 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+	"github.com/matryer/is"
+)
+
+const testPeerSecret = "s3cret"
+
+type testPeer struct {
+	store *PeerStore
+	srv   *httptest.Server
+}
+
+// newTestPeers starts n replicas knowing about each other.
+func newTestPeers(t *testing.T, n int) []testPeer {
+	t.Helper()
+
+	peers := make([]testPeer, n)
+	urls := make([]string, n)
+
+	for i := range peers {
+		p := &peers[i]
+		p.srv = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			p.store.Handler().ServeHTTP(rw, r)
+		}))
+		t.Cleanup(p.srv.Close)
+
+		urls[i] = p.srv.URL
+	}
+
+	for i := range peers {
+		s, err := NewPeerStore(PeerOptions{
+			Self:         urls[i],
+			Peers:        urls,
+			HotThreshold: 2,
+			HotTTL:       time.Minute,
+			Timeout:      time.Second,
+			Secret:       testPeerSecret,
+		},
+			NewMemoryStore(memcache.NewMemoryCache(time.Minute, 4096, 1024)),
+			NewMemoryStore(memcache.NewMemoryCache(time.Minute, 4096, 1024)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peers[i].store = s
+	}
+
+	return peers
+}
+
+func TestPeerStore(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	peers := newTestPeers(t, 3)
+
+	// store through one replica, read through every replica
+	for i := range 10 {
+		k := "k" + strconv.Itoa(i)
+		eval.NoErr(peers[i%3].store.Set(ctx, k, &Record{StatusCode: 200, Body: []byte(k)}, time.Minute))
+	}
+
+	owned := 0
+	for _, p := range peers {
+		stats, err := p.store.local.Stats(ctx)
+		eval.NoErr(err)
+		owned += stats.Records
+
+		for i := range 10 {
+			k := "k" + strconv.Itoa(i)
+
+			r, err := p.store.Get(ctx, k)
+			eval.NoErr(err)
+			eval.Equal(r.Body, []byte(k))
+		}
+	}
+
+	// every record is kept once, by its owner
+	eval.Equal(owned, 10)
+
+	// deleting through any replica removes the record from its owner
+	removed, err := peers[0].store.Remove(ctx, "k1")
+	eval.NoErr(err)
+	eval.True(removed)
+
+	removed, err = peers[0].store.Remove(ctx, "k1")
+	eval.NoErr(err)
+	eval.True(!removed)
+
+	eval.NoErr(peers[0].store.Delete(ctx, "k1")) // missing keys aren't an error
+
+	owner := peers[0].store.owner("k1")
+	for _, p := range peers {
+		if p.srv.URL == owner {
+			_, err := p.store.Get(ctx, "k1")
+			eval.True(errors.Is(err, ErrNotFound))
+		}
+	}
+}
+
+func TestPeerStoreHotKeys(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	peers := newTestPeers(t, 2)
+
+	// find a key owned by the second replica
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := "k" + strconv.Itoa(i); peers[0].store.owner(k) == peers[1].srv.URL {
+			key = k
+		}
+	}
+
+	eval.NoErr(peers[1].store.Set(ctx, key, &Record{Body: []byte("hot")}, time.Minute))
+
+	for range 2 {
+		_, err := peers[0].store.Get(ctx, key)
+		eval.NoErr(err)
+	}
+
+	// mirrored after HotThreshold fetches, so the owner going away is invisible
+	peers[1].srv.Close()
+
+	r, err := peers[0].store.Get(ctx, key)
+	eval.NoErr(err)
+	eval.Equal(r.Body, []byte("hot"))
+
+	// keys not mirrored fail open
+	var other string
+	for i := 0; other == ""; i++ {
+		if k := "o" + strconv.Itoa(i); peers[0].store.owner(k) == peers[1].srv.URL {
+			other = k
+		}
+	}
+
+	_, err = peers[0].store.Get(ctx, other)
+	eval.True(errors.Is(err, ErrNotFound))
+
+	err = peers[0].store.Set(ctx, other, &Record{}, time.Minute)
+	eval.True(errors.Is(err, ErrUnavailable))
+}
+
+func TestPeerStoreRemoteHitsBounded(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	peers := newTestPeers(t, 2)
+	peers[0].store.maxRemoteHits = 5
+
+	// keys fetched once from the other replica are never mirrored
+	fetched := 0
+	for i := 0; fetched < 20; i++ {
+		k := "k" + strconv.Itoa(i)
+		if peers[0].store.owner(k) != peers[1].srv.URL {
+			continue
+		}
+
+		eval.NoErr(peers[1].store.Set(ctx, k, &Record{Body: []byte(k)}, time.Minute))
+
+		_, err := peers[0].store.Get(ctx, k)
+		eval.NoErr(err)
+
+		fetched++
+	}
+
+	peers[0].store.mu.RLock()
+	defer peers[0].store.mu.RUnlock()
+
+	eval.True(len(peers[0].store.remoteHits) <= 5)
+}
+
+func TestPeerStoreRetryBackoff(t *testing.T) {
+	eval := is.New(t)
+
+	var requests atomic.Int32
+
+	// a replica too slow to answer
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	s, err := NewPeerStore(PeerOptions{
+		Self:         "http://self",
+		Peers:        []string{slow.URL},
+		Timeout:      50 * time.Millisecond,
+		RetryBackoff: time.Minute,
+		Secret:       testPeerSecret,
+	},
+		NewMemoryStore(memcache.NewMemoryCache(time.Minute, 1024, 1024)),
+		NewMemoryStore(memcache.NewMemoryCache(time.Minute, 1024, 1024)),
+	)
+	eval.NoErr(err)
+	defer func() { _ = s.Close() }()
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := "k" + strconv.Itoa(i); s.owner(k) == slow.URL {
+			key = k
+		}
+	}
+
+	// a client giving up doesn't mark the replica down
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.Get(ctx, key)
+	eval.True(errors.Is(err, ErrNotFound))
+
+	_, err = s.Get(context.Background(), key)
+	eval.True(errors.Is(err, ErrNotFound))
+	eval.Equal(requests.Load(), int32(1)) // the cancelled request was never sent
+
+	// the timeout did, so its keys miss without waiting
+	start := time.Now()
+
+	_, err = s.Get(context.Background(), key)
+	eval.True(errors.Is(err, ErrNotFound))
+	eval.True(time.Since(start) < 50*time.Millisecond)
+
+	err = s.Set(context.Background(), key, &Record{}, time.Minute)
+	eval.True(errors.Is(err, ErrUnavailable))
+	eval.Equal(requests.Load(), int32(1))
+}
+
+func TestPeerStoreFileDiscovery(t *testing.T) {
+	eval := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "peers")
+	eval.NoErr(os.WriteFile(path, []byte("# replicas\nhttp://a\n\nhttp://b/\n"), 0o644))
+
+	s, err := NewPeerStore(PeerOptions{
+		Self:            "http://a",
+		PeersFile:       path,
+		RefreshInterval: 10 * time.Millisecond,
+		Secret:          testPeerSecret,
+	},
+		NewMemoryStore(memcache.NewMemoryCache(time.Minute, 1024, 1024)),
+		NewMemoryStore(memcache.NewMemoryCache(time.Minute, 1024, 1024)),
+	)
+	eval.NoErr(err)
+	defer func() { _ = s.Close() }()
+
+	eval.Equal(s.peers, []string{"http://a", "http://b"})
+
+	eval.NoErr(os.WriteFile(path, []byte("http://a\nhttp://c\n"), 0o644))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.RLock()
+		peers := strings.Join(s.peers, ",")
+		s.mu.RUnlock()
+
+		if peers == "http://a,http://c" {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("peers file change was not picked up")
+}
+
+func TestPeerStoreAuthentication(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	peers := newTestPeers(t, 1)
+	eval.NoErr(peers[0].store.Set(ctx, "k", &Record{StatusCode: 200, Body: []byte("genuine")}, time.Minute))
+
+	poisoned := &Record{StatusCode: 200, Body: []byte("poisoned")}
+	poisoned.Stamp(time.Now(), time.Minute)
+	data, err := poisoned.MarshalBinary()
+	eval.NoErr(err)
+
+	for _, authorization := range []string{"", "Bearer wrong", testPeerSecret} {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			req, err := http.NewRequest(method, peers[0].srv.URL+peerRecordsPath+"?key=k", bytes.NewReader(data))
+			eval.NoErr(err)
+
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			eval.NoErr(err)
+			_ = resp.Body.Close()
+
+			eval.Equal(resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	r, err := peers[0].store.Get(ctx, "k")
+	eval.NoErr(err)
+	eval.Equal(r.Body, []byte("genuine"))
+
+	_, err = NewPeerStore(PeerOptions{Self: "http://a"},
+		NewMemoryStore(memcache.NewMemoryCache(time.Minute, 1024, 1024)),
+		NewMemoryStore(memcache.NewMemoryCache(time.Minute, 1024, 1024)),
+	)
+	eval.True(err != nil) // no secret
+}
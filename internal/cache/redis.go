@@ -0,0 +1,347 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+const BackendRedis = "redis"
+
+var ErrUnavailable = errors.New("cache server unavailable")
+
+// RedisOptions configures a RedisStore.
+type RedisOptions struct {
+	Addr      string
+	Password  string
+	DB        int
+	KeyPrefix string
+
+	PoolSize      int
+	MaxRecordSize int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// RetryBackoff is how long the store stays failed open, answering every
+	// Get with a miss without contacting the server, after a connection error.
+	RetryBackoff time.Duration
+}
+
+// RedisStore keeps records in a server speaking the Redis (RESP) protocol,
+// letting several proxy replicas share one cache. Records expire using the
+// server's native TTLs.
+//
+// The store fails open: when the server can't be reached Get reports a miss and
+// Set returns ErrUnavailable, so requests keep being served by the upstream.
+type RedisStore struct {
+	opts RedisOptions
+	pool *connPool
+
+	mu        sync.Mutex
+	stats     Stats
+	downUntil time.Time
+}
+
+func NewRedisStore(opts RedisOptions) *RedisStore {
+	s := &RedisStore{opts: opts}
+
+	s.pool = newConnPool(opts.PoolSize, func(ctx context.Context) (*bufConn, error) {
+		c, err := dialConn(ctx, opts.Addr, opts.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.Password != "" {
+			if _, err := s.do(c, "AUTH", opts.Password); err != nil {
+				_ = c.Close()
+
+				return nil, fmt.Errorf("failed to authenticate: %w", err)
+			}
+		}
+
+		if opts.DB != 0 {
+			if _, err := s.do(c, "SELECT", strconv.Itoa(opts.DB)); err != nil {
+				_ = c.Close()
+
+				return nil, fmt.Errorf("failed to select database: %w", err)
+			}
+		}
+
+		return c, nil
+	})
+
+	return s
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (*Record, error) {
+	reply, err := s.command(ctx, "GET", s.opts.KeyPrefix+key)
+	if err != nil {
+		s.count(func(stats *Stats) { stats.Misses++ })
+
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		s.count(func(stats *Stats) { stats.Misses++ })
+
+		return nil, ErrNotFound
+	}
+
+	var r Record
+	if err := r.UnmarshalBinary(data); err != nil {
+		slog.Error("failed to decode cached record", "key", key, "error", err)
+		s.count(func(stats *Stats) { stats.Misses++ })
+
+		return nil, ErrNotFound
+	}
+
+	if r.Expired(time.Now()) {
+		s.count(func(stats *Stats) {
+			stats.Misses++
+			stats.StaleHits++
+		})
+
+		return nil, ErrExpired
+	}
+
+	s.count(func(stats *Stats) { stats.Hits++ })
+
+	return &r, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, r *Record, ttl time.Duration) error {
+	r.Stamp(time.Now(), ttl)
+
+	data, err := r.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if s.opts.MaxRecordSize > 0 && len(data) > s.opts.MaxRecordSize {
+		s.count(func(stats *Stats) { stats.RejectedTooLarge++ })
+
+		return memcache.ErrMaxRecordSizeExceed
+	}
+
+	ms := max(ttl.Milliseconds(), 1)
+
+	_, err = s.command(ctx, "SET", s.opts.KeyPrefix+key, string(data), "PX", strconv.FormatInt(ms, 10))
+
+	return err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Remove(ctx, key)
+
+	return err
+}
+
+func (s *RedisStore) Remove(ctx context.Context, key string) (bool, error) {
+	reply, err := s.command(ctx, "DEL", s.opts.KeyPrefix+key)
+	if err != nil {
+		return false, err
+	}
+
+	n, _ := reply.(int64)
+	if n > 0 {
+		s.count(func(stats *Stats) { stats.Evictions.Purged += n })
+	}
+
+	return n > 0, nil
+}
+
+// Stats returns the counters of this replica only; the server is shared.
+func (s *RedisStore) Stats(_ context.Context) (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.pool.Close()
+}
+
+func (s *RedisStore) count(f func(stats *Stats)) {
+	s.mu.Lock()
+	f(&s.stats)
+	s.mu.Unlock()
+}
+
+// command runs a single command on a pooled connection. Connection errors
+// mark the server as unavailable for RetryBackoff.
+func (s *RedisStore) command(ctx context.Context, args ...string) (any, error) {
+	s.mu.Lock()
+	down := time.Now().Before(s.downUntil)
+	s.mu.Unlock()
+
+	if down {
+		return nil, ErrUnavailable
+	}
+
+	c, err := s.pool.Get(ctx)
+	if err != nil {
+		// A client giving up says nothing about the server.
+		if ctx.Err() == nil {
+			s.markDown(err)
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	reply, err := s.do(c, args...)
+
+	var respErr respError
+	if err != nil && !errors.As(err, &respErr) {
+		_ = c.Close()
+		s.pool.Put(nil)
+		s.markDown(err)
+
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	s.pool.Put(c)
+
+	return reply, err
+}
+
+func (s *RedisStore) do(c *bufConn, args ...string) (any, error) {
+	if s.opts.WriteTimeout > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(s.opts.WriteTimeout))
+	}
+
+	if err := c.writeRESPCommand(args...); err != nil {
+		return nil, err
+	}
+
+	if s.opts.ReadTimeout > 0 {
+		_ = c.SetReadDeadline(time.Now().Add(s.opts.ReadTimeout))
+	}
+
+	maxSize := s.opts.MaxRecordSize
+	if maxSize <= 0 {
+		maxSize = maxRESPBulkLen
+	}
+
+	return c.readRESPReply(maxSize)
+}
+
+func (s *RedisStore) markDown(err error) {
+	slog.Error("cache server unavailable, failing open", "addr", s.opts.Addr, "error", err)
+
+	s.mu.Lock()
+	s.downUntil = time.Now().Add(s.opts.RetryBackoff)
+	s.mu.Unlock()
+}
+
+type respError string
+
+func (e respError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (c *bufConn) writeRESPCommand(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	return c.w.Flush()
+}
+
+const (
+	// maxRESPBulkLen bounds bulk strings when records aren't, as the
+	// server's own proto-max-bulk-len does by default.
+	maxRESPBulkLen = 512 * 1024 * 1024
+
+	// maxRESPArrayLen bounds arrays, of which the store reads none larger
+	// than a few items.
+	maxRESPArrayLen = 1024
+)
+
+// readRESPReply reads a RESP reply. Bulk strings are returned as []byte (nil for
+// the null bulk string), integers as int64, simple strings as string, arrays
+// as []any and error replies as a respError. Bulk strings longer than maxSize
+// and arrays longer than maxRESPArrayLen are rejected before anything is
+// allocated for them.
+func (c *bufConn) readRESPReply(maxSize int) (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, respError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case n == -1:
+			return nil, nil
+		case n < 0:
+			return nil, fmt.Errorf("malformed bulk string length %d", n)
+		case n > maxSize:
+			return nil, fmt.Errorf("bulk string of %d bytes exceeds the limit of %d", n, maxSize)
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case n == -1:
+			return nil, nil
+		case n < 0:
+			return nil, fmt.Errorf("malformed array length %d", n)
+		case n > maxRESPArrayLen:
+			return nil, fmt.Errorf("array of %d items exceeds the limit of %d", n, maxRESPArrayLen)
+		}
+
+		items := make([]any, 0, n)
+		for range n {
+			item, err := c.readRESPReply(maxSize)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", kind)
+	}
+}
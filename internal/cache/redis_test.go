@@ -0,0 +1,357 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+	"github.com/matryer/is"
+)
+
+// fakeRedis is a minimal in-process server implementing the RESP commands
+// used by RedisStore.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+	conns    atomic.Int32
+
+	mu     sync.Mutex
+	values map[string]string
+	expiry map[string]time.Time
+	open   []net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		ln:       ln,
+		password: password,
+		values:   make(map[string]string),
+		expiry:   make(map[string]time.Time),
+	}
+
+	go f.serve()
+
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeRedis) Addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		f.conns.Add(1)
+
+		f.mu.Lock()
+		f.open = append(f.open, conn)
+		f.mu.Unlock()
+
+		go f.handle(conn)
+	}
+}
+
+// Close stops the server and drops every open connection.
+func (f *fakeRedis) Close() {
+	_ = f.ln.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.open {
+		_ = c.Close()
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+
+		var reply string
+
+		switch {
+		case cmd == "AUTH":
+			authed = len(args) == 2 && args[1] == f.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SET":
+			f.mu.Lock()
+			f.values[args[1]] = args[2]
+			if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+				ms, _ := strconv.Atoi(args[4])
+				f.expiry[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+			f.mu.Unlock()
+
+			reply = "+OK\r\n"
+		case cmd == "GET":
+			if v, ok := f.get(args[1]); ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case cmd == "DEL":
+			_, ok := f.get(args[1])
+
+			f.mu.Lock()
+			delete(f.values, args[1])
+			f.mu.Unlock()
+
+			reply = ":0\r\n"
+			if ok {
+				reply = ":1\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) get(k string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if exp, ok := f.expiry[k]; ok && time.Now().After(exp) {
+		delete(f.values, k)
+		delete(f.expiry, k)
+	}
+
+	v, ok := f.values[k]
+
+	return v, ok
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for range n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+func newTestRedisStore(addr, password string) *RedisStore {
+	return NewRedisStore(RedisOptions{
+		Addr:          addr,
+		Password:      password,
+		KeyPrefix:     "test:",
+		PoolSize:      2,
+		MaxRecordSize: 1024,
+		DialTimeout:   100 * time.Millisecond,
+		ReadTimeout:   100 * time.Millisecond,
+		WriteTimeout:  100 * time.Millisecond,
+		RetryBackoff:  time.Minute,
+	})
+}
+
+func TestRedisStore(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	srv := newFakeRedis(t, "secret")
+
+	s := newTestRedisStore(srv.Addr(), "secret")
+	defer func() { _ = s.Close() }()
+
+	_, err := s.Get(ctx, "k")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	eval.NoErr(s.Set(ctx, "k", &Record{StatusCode: 200, Body: []byte("body\r\n")}, time.Minute))
+
+	r, err := s.Get(ctx, "k")
+	eval.NoErr(err)
+	eval.Equal(r.StatusCode, 200)
+	eval.Equal(r.Body, []byte("body\r\n"))
+
+	_, ok := srv.get("test:k")
+	eval.True(ok)
+
+	err = s.Set(ctx, "large", &Record{Body: make([]byte, 2048)}, time.Minute)
+	eval.Equal(err, memcache.ErrMaxRecordSizeExceed)
+
+	// native TTL
+	eval.NoErr(s.Set(ctx, "short", &Record{}, 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = s.Get(ctx, "short")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	eval.NoErr(s.Delete(ctx, "k"))
+
+	_, err = s.Get(ctx, "k")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	stats, err := s.Stats(ctx)
+	eval.NoErr(err)
+	eval.Equal(stats.Hits, int64(1))
+	eval.Equal(stats.Misses, int64(3))
+	eval.Equal(stats.RejectedTooLarge, int64(1))
+	eval.Equal(stats.Evictions.Purged, int64(1))
+
+	// every command reused the pooled connection
+	eval.Equal(srv.conns.Load(), int32(1))
+}
+
+func TestRedisStoreWrongPassword(t *testing.T) {
+	eval := is.New(t)
+
+	srv := newFakeRedis(t, "secret")
+
+	s := newTestRedisStore(srv.Addr(), "wrong")
+	defer func() { _ = s.Close() }()
+
+	err := s.Set(context.Background(), "k", &Record{}, time.Minute)
+	eval.True(errors.Is(err, ErrUnavailable))
+}
+
+func TestRedisStoreCancelledContext(t *testing.T) {
+	eval := is.New(t)
+
+	srv := newFakeRedis(t, "")
+
+	s := newTestRedisStore(srv.Addr(), "")
+	defer func() { _ = s.Close() }()
+
+	eval.NoErr(s.Set(context.Background(), "k", &Record{}, time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for range 10 {
+		_, _ = s.Get(ctx, "k")
+	}
+
+	// The server isn't considered down because of an impatient client.
+	_, err := s.Get(context.Background(), "k")
+	eval.NoErr(err)
+}
+
+func TestRedisStoreFailOpen(t *testing.T) {
+	eval := is.New(t)
+	ctx := context.Background()
+
+	srv := newFakeRedis(t, "")
+
+	s := newTestRedisStore(srv.Addr(), "")
+	defer func() { _ = s.Close() }()
+
+	eval.NoErr(s.Set(ctx, "k", &Record{}, time.Minute))
+
+	srv.Close()
+
+	_, err := s.Get(ctx, "missing")
+	eval.True(errors.Is(err, ErrNotFound))
+
+	// Further requests are answered as misses without waiting on the server.
+	start := time.Now()
+
+	for range 10 {
+		_, err = s.Get(ctx, "k")
+		eval.True(errors.Is(err, ErrNotFound))
+		eval.True(errors.Is(err, ErrUnavailable))
+
+		err = s.Set(ctx, "k", &Record{}, time.Minute)
+		eval.True(errors.Is(err, ErrUnavailable))
+	}
+
+	eval.True(time.Since(start) < 50*time.Millisecond)
+}
+
+func TestReadRESPReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    any
+		wantErr bool
+	}{
+		{name: "bulk string", in: "$3\r\nabc\r\n", want: []byte("abc")},
+		{name: "null bulk string", in: "$-1\r\n", want: nil},
+		{name: "negative bulk string", in: "$-2\r\n", wantErr: true},
+		{name: "bulk string too large", in: "$1025\r\n", wantErr: true},
+		{name: "huge bulk string", in: "$9223372036854775807\r\n", wantErr: true},
+		{name: "array", in: "*2\r\n:1\r\n+OK\r\n", want: []any{int64(1), "OK"}},
+		{name: "null array", in: "*-1\r\n", want: nil},
+		{name: "negative array", in: "*-2\r\n", wantErr: true},
+		{name: "array too large", in: "*100000000\r\n", wantErr: true},
+		{name: "nested bulk string too large", in: "*1\r\n$2048\r\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			c := &bufConn{r: bufio.NewReader(strings.NewReader(tt.in))}
+
+			got, err := c.readRESPReply(1024)
+			eval.Equal(err != nil, tt.wantErr)
+
+			if !tt.wantErr {
+				eval.Equal(fmt.Sprint(got), fmt.Sprint(tt.want))
+			}
+		})
+	}
+}
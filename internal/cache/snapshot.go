@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+// SaveSnapshot atomically writes the fresh records of s to the file at path.
+func (s *MemoryStore) SaveSnapshot(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err := memcache.WriteSnapshot(tmp, s.cache.Snapshot()); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot restores the records saved to the file at path, dropping the
+// ones that expired since, and returns how many were restored. A missing
+// file restores nothing; a corrupted one returns memcache.ErrInvalidSnapshot.
+func (s *MemoryStore) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	entries, err := memcache.ReadSnapshot(f)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	restored := 0
+
+	for _, e := range entries {
+		if e.Record.Expired(now) {
+			continue
+		}
+
+		if err := s.cache.Restore(e.Key, e.Record); err == nil {
+			restored++
+		}
+	}
+
+	return restored, nil
+}
+
+// StartSnapshots saves a snapshot to path every interval, if positive, and
+// once more when the store is closed.
+func (s *MemoryStore) StartSnapshots(path string, interval time.Duration) {
+	s.snapshotPath = path
+
+	if interval <= 0 {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go s.saveSnapshots(interval)
+}
+
+func (s *MemoryStore) saveSnapshots(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.SaveSnapshot(s.snapshotPath); err != nil {
+				slog.Error("failed to save cache snapshot", "path", s.snapshotPath, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+)
+
+// TieredStore keeps small, hot records in memory in front of a larger store.
+// Records found only in the second tier are promoted to memory on a hit.
+type TieredStore struct {
+	l1 *MemoryStore
+	l2 Store
+}
+
+func NewTieredStore(l1 *MemoryStore, l2 Store) *TieredStore {
+	return &TieredStore{l1: l1, l2: l2}
+}
+
+func (s *TieredStore) Get(ctx context.Context, key string) (*Record, error) {
+	r, err := s.l1.Get(ctx, key)
+	if err == nil {
+		return r, nil
+	}
+
+	r, err2 := s.l2.Get(ctx, key)
+	if err2 != nil {
+		// Report the record as expired if either tier found it expired.
+		if errors.Is(err, ErrExpired) {
+			return nil, err
+		}
+
+		return nil, err2
+	}
+
+	if err := s.l1.Restore(key, r); err != nil {
+		slog.Debug("Record not promoted to memory", "key", key, "error", err)
+	}
+
+	return r, nil
+}
+
+// Set stores r in both tiers. It fails only if neither tier accepted the record.
+func (s *TieredStore) Set(ctx context.Context, key string, r *Record, ttl time.Duration) error {
+	err2 := s.l2.Set(ctx, key, r, ttl)
+
+	if err := s.l1.Restore(key, r); err != nil && err2 != nil {
+		return err2
+	}
+
+	return nil
+}
+
+func (s *TieredStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Remove(ctx, key)
+
+	return err
+}
+
+// Remove deletes the record from both tiers, reporting whether either held
+// it.
+func (s *TieredStore) Remove(ctx context.Context, key string) (bool, error) {
+	ok1, err1 := s.l1.Remove(ctx, key)
+	ok2, err2 := Remove(ctx, s.l2, key)
+
+	return ok1 || ok2, errors.Join(err1, err2)
+}
+
+// Stats reports the hits of both tiers. Every other counter comes from the
+// second tier, which sees every memory miss and holds every record.
+func (s *TieredStore) Stats(ctx context.Context) (Stats, error) {
+	stats1, err := s.l1.Stats(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats2, err := s.l2.Stats(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := stats2
+	stats.Hits += stats1.Hits
+
+	return stats, nil
+}
+
+func (s *TieredStore) Entries(ctx context.Context, f memcache.EntryFilter) ([]memcache.EntryInfo, int, error) {
+	if lister, ok := s.l2.(Lister); ok {
+		return lister.Entries(ctx, f)
+	}
+
+	return s.l1.Entries(ctx, f)
+}
+
+func (s *TieredStore) Purge(ctx context.Context) (int, error) {
+	if _, err := s.l1.Purge(ctx); err != nil {
+		return 0, err
+	}
+
+	if purger, ok := s.l2.(Purger); ok {
+		return purger.Purge(ctx)
+	}
+
+	return 0, nil
+}
+
+func (s *TieredStore) Close() error {
+	return errors.Join(s.l1.Close(), Close(s.l2))
+}
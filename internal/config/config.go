@@ -1,6 +1,11 @@
 package config
 
-import "time"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
 
 const (
 	DefaultUpstreamURL = "http://httpbin.org"
@@ -11,26 +16,124 @@ const (
 	DefaultWriteTimeout    = 10 * time.Second
 	DefaultIdleTimeout     = 120 * time.Second
 
-	DefaultCacheTTL           = 1 * time.Minute
-	DefaultMaxCacheSize       = 1 * 1024 * 1024
-	DefaultMaxCacheRecordSize = 1 * 1024
+	DefaultTLSListenPort     = 8443
+	DefaultTLSMinVersion     = "1.2"
+	DefaultTLSReloadInterval = 10 * time.Second
+
+	DefaultTLSClientAuth              = "optional"
+	DefaultTLSClientSubjectHeader     = "X-Client-Subject"
+	DefaultTLSClientSANsHeader        = "X-Client-SANs"
+	DefaultTLSClientFingerprintHeader = "X-Client-Fingerprint"
+
+	DefaultCacheBackend       = "memory"
+	DefaultCacheEviction      = "lru"
+	DefaultCacheShards        = 16
+	DefaultCacheSweepInterval = 30 * time.Second
+	DefaultCacheSweepLimit    = 1000
+	DefaultCacheMemoryCheck   = 1 * time.Second
+
+	DefaultCacheRefreshMinHits     = 2
+	DefaultCacheRefreshConcurrency = 4
+	DefaultCacheRefreshTimeout     = 30 * time.Second
+
+	DefaultCacheCompressionMinSize = 256
+	DefaultCacheSnapshotInterval   = 5 * time.Minute
+	DefaultCacheTTL                = 1 * time.Minute
+	DefaultCacheNegativeTTL        = 10 * time.Second
+	DefaultMaxCacheSize            = 1 * 1024 * 1024
+	DefaultMaxCacheRecordSize      = 64 * 1024
 
 	DefaultTransportMaxIdleConnections  = 100
 	DefaultTransportMaxIdleConnsPerHost = 20
 	DefaultTransportIdleConnTimeout     = 90 * time.Second
 	DefaultTransportDialTimeout         = 5 * time.Second
+
+	DefaultCacheStatusName = "reverseproxy"
+
+	DefaultDiskCacheDirName       = "reverseproxy-cache"
+	DefaultDiskCacheMaxSize       = 1 * 1024 * 1024 * 1024
+	DefaultDiskCacheMaxRecordSize = 10 * 1024 * 1024
+
+	DefaultRedisAddr          = "localhost:6379"
+	DefaultRedisKeyPrefix     = "reverseproxy:"
+	DefaultRedisPoolSize      = 10
+	DefaultRedisMaxRecordSize = 1 * 1024 * 1024
+	DefaultRedisDialTimeout   = 500 * time.Millisecond
+	DefaultRedisReadTimeout   = 500 * time.Millisecond
+	DefaultRedisWriteTimeout  = 500 * time.Millisecond
+	DefaultRedisRetryBackoff  = 5 * time.Second
+
+	DefaultMemcachedServer        = "localhost:11211"
+	DefaultMemcachedKeyPrefix     = "reverseproxy:"
+	DefaultMemcachedPoolSize      = 10
+	DefaultMemcachedMaxItemSize   = 1000 * 1024
+	DefaultMemcachedMaxRecordSize = 10 * 1024 * 1024
+	DefaultMemcachedDialTimeout   = 500 * time.Millisecond
+	DefaultMemcachedReadTimeout   = 500 * time.Millisecond
+	DefaultMemcachedWriteTimeout  = 500 * time.Millisecond
+	DefaultMemcachedRetryBackoff  = 5 * time.Second
+
+	DefaultPeerListenHost      = "127.0.0.1"
+	DefaultPeerListenPort      = 8082
+	DefaultPeerRefreshInterval = 10 * time.Second
+	DefaultPeerHotThreshold    = 3
+	DefaultPeerHotTTL          = 10 * time.Second
+	DefaultPeerTimeout         = 500 * time.Millisecond
+	DefaultPeerRetryBackoff    = 5 * time.Second
+
+	DefaultAdminListenHost = "127.0.0.1"
+	DefaultAdminListenPort = 8081
+
+	DefaultWarmConcurrency = 4
+	DefaultWarmRate        = 10
 )
 
+// DefaultCacheCompressionTypes returns the media types whose bodies are
+// compressed by default. A type ending in "/*" matches all its subtypes.
+func DefaultCacheCompressionTypes() []string {
+	return []string{"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+}
+
 type Config struct {
 	LogLevel string
 	Proxy    ProxyConfig
 	Cache    CacheConfig
+	Admin    AdminConfig
 }
 
 type ProxyConfig struct {
 	Server    HTTPServerConfig
 	TargetURL string
 	Transport TransportConfig
+	// RoutesFile, when set, is a JSON file of per-route settings.
+	RoutesFile string
+	TLS        TLSConfig
+}
+
+// TLSConfig configures the HTTPS listener, which is enabled when CertFiles
+// is set. CertFiles and KeyFiles are paired by position; the certificate
+// presented is chosen by the server name clients ask for, defaulting to the
+// first. The files are checked for changes every ReloadInterval.
+type TLSConfig struct {
+	ListenPort     int
+	CertFiles      []string
+	KeyFiles       []string
+	MinVersion     string
+	CipherSuites   []string
+	ReloadInterval time.Duration
+
+	// ClientCAFile, when set, is a PEM bundle of the CAs client
+	// certificates are verified against, and that sign the CRLs of CRLFile.
+	ClientCAFile string
+	CRLFile      string
+	// ClientAuth is whether routes require a client certificate by
+	// default: require, optional or none.
+	ClientAuth string
+	// The subject, SANs and SHA-256 fingerprint of verified client
+	// certificates are forwarded to the upstream in these headers.
+	ClientSubjectHeader     string
+	ClientSANsHeader        string
+	ClientFingerprintHeader string
 }
 
 type TransportConfig struct {
@@ -38,20 +141,178 @@ type TransportConfig struct {
 	MaxIdleConnsPerHost int
 	IdleConnTimeout     time.Duration
 	DialTimeout         time.Duration
+	TLS                 UpstreamTLSConfig
+
+	// HTTP2 speaks HTTP/2 to https upstreams that support it, and H2C
+	// speaks it without TLS to http ones, which must support it.
+	HTTP2 bool
+	H2C   bool
+
+	// MaxInFlight, when set, bounds the requests in flight to the upstream
+	// at once, over HTTP/1 and HTTP/2 alike. A request is in flight until
+	// its response is read, streamed ones included.
+	MaxInFlight int
+}
+
+// UpstreamTLSConfig configures the TLS connections to the upstream.
+type UpstreamTLSConfig struct {
+	// CAFile, when set, is a PEM bundle of the CAs trusted instead of the
+	// system's.
+	CAFile string
+	// CertFile and KeyFile are the client certificate presented to the
+	// upstream, if any.
+	CertFile string
+	KeyFile  string
+	// ServerName, when set, is the name sent in SNI and the upstream's
+	// certificate is verified for, instead of the target URL's host.
+	ServerName string
+	// PinnedSPKI lists base64 SHA-256 hashes of public keys, one of which
+	// a certificate of the upstream's chain must have.
+	PinnedSPKI []string
+	// InsecureSkipVerify disables the verification of the upstream's
+	// certificate. It is meant for development only.
+	InsecureSkipVerify bool
 }
 
 type HTTPServerConfig struct {
 	ListenPort int
 
+	// H2C accepts HTTP/2 without TLS on the plain listener, besides
+	// HTTP/1. HTTP/2 is always accepted over TLS.
+	H2C bool
+	// MaxConcurrentStreams is the number of requests an HTTP/2 client may
+	// send at once on a connection; Go's default when zero.
+	MaxConcurrentStreams int
+
 	ShutdownTimeout time.Duration
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	IdleTimeout     time.Duration
 }
+
 type CacheConfig struct {
-	TTL           time.Duration
+	Backend        string
+	EvictionPolicy string
+	Shards         int
+	SweepInterval  time.Duration
+	SweepLimit     int
+	TTL            time.Duration
+
+	// StatusCodes lists the status codes stored in addition to those RFC
+	// 9110 defines as heuristically cacheable. Client and server errors are
+	// stored for NegativeTTL, or not at all if it is negative.
+	StatusCodes []int
+	NegativeTTL time.Duration
+
 	MaxSize       int
 	MaxRecordSize int
+
+	// MemoryLimitRatio, when set, sizes the in-memory cache as this fraction
+	// of the Go runtime memory limit (GOMEMLIMIT) instead of MaxSize, and
+	// evicts records when the process nears the limit.
+	MemoryLimitRatio    float64
+	MemoryCheckInterval time.Duration
+
+	// RefreshAhead, when set, is the fraction of a record's lifetime, at its
+	// end, within which RefreshMinHits hits trigger a background refresh of
+	// the record. At most RefreshConcurrency refreshes run at once.
+	RefreshAhead       float64
+	RefreshMinHits     int
+	RefreshConcurrency int
+	RefreshTimeout     time.Duration
+
+	// Compression is the encoding eligible bodies are stored with: gzip, br
+	// or zstd. Bodies are stored as received when it is empty.
+	Compression        string
+	CompressionMinSize int
+	CompressionTypes   []string
+
+	// SnapshotFile, when set, is where the in-memory cache is saved every
+	// SnapshotInterval and on shutdown, and restored from on startup.
+	SnapshotFile     string
+	SnapshotInterval time.Duration
+
+	// UserPartition stores the responses to authenticated requests that may
+	// not be shared, such as private ones, per user. Users are identified
+	// by UserPartitionHeader, set by a trusted authentication layer, or by
+	// their Authorization header if it is empty.
+	UserPartition       bool
+	UserPartitionHeader string
+
+	DisableAgeHeader         bool
+	DisableCacheStatusHeader bool
+	StatusName               string
+	XCacheHeader             bool
+
+	Disk      DiskCacheConfig
+	Redis     RedisCacheConfig
+	Memcached MemcachedCacheConfig
+	Peers     PeerCacheConfig
+	Warm      WarmCacheConfig
+}
+
+type DiskCacheConfig struct {
+	Dir           string
+	MaxSize       int
+	MaxRecordSize int
+}
+
+type RedisCacheConfig struct {
+	Addr          string
+	Password      string
+	DB            int
+	KeyPrefix     string
+	PoolSize      int
+	MaxRecordSize int
+	DialTimeout   time.Duration
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	RetryBackoff  time.Duration
+}
+
+type MemcachedCacheConfig struct {
+	Servers       []string
+	KeyPrefix     string
+	PoolSize      int
+	MaxItemSize   int
+	MaxRecordSize int
+	DialTimeout   time.Duration
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	RetryBackoff  time.Duration
+}
+
+type PeerCacheConfig struct {
+	// ListenHost is the address the peer listener binds. It is loopback
+	// by default; replicas on other hosts need a private address.
+	ListenHost      string
+	ListenPort      int
+	Self            string
+	URLs            []string
+	File            string
+	RefreshInterval time.Duration
+	HotThreshold    int
+	HotTTL          time.Duration
+	Timeout         time.Duration
+	RetryBackoff    time.Duration
+	// Secret is shared by the replicas, which send it with every request
+	// to each other. It is required.
+	Secret string
+}
+
+// WarmCacheConfig bounds the requests made when warming the cache.
+type WarmCacheConfig struct {
+	Concurrency int
+	// Rate is the maximum number of requests per second.
+	Rate float64
+}
+
+type AdminConfig struct {
+	Enabled bool
+	// ListenHost is the address the admin listener binds. It is loopback
+	// by default, as the admin API has no authentication of its own.
+	ListenHost string
+	ListenPort int
 }
 
 func (config *Config) SetDefaults() {
@@ -81,6 +342,34 @@ func (config *Config) SetDefaults() {
 		config.Proxy.Server.IdleTimeout = DefaultIdleTimeout
 	}
 
+	if config.Proxy.TLS.ListenPort == 0 {
+		config.Proxy.TLS.ListenPort = DefaultTLSListenPort
+	}
+
+	if config.Proxy.TLS.MinVersion == "" {
+		config.Proxy.TLS.MinVersion = DefaultTLSMinVersion
+	}
+
+	if config.Proxy.TLS.ReloadInterval == 0 {
+		config.Proxy.TLS.ReloadInterval = DefaultTLSReloadInterval
+	}
+
+	if config.Proxy.TLS.ClientAuth == "" {
+		config.Proxy.TLS.ClientAuth = DefaultTLSClientAuth
+	}
+
+	if config.Proxy.TLS.ClientSubjectHeader == "" {
+		config.Proxy.TLS.ClientSubjectHeader = DefaultTLSClientSubjectHeader
+	}
+
+	if config.Proxy.TLS.ClientSANsHeader == "" {
+		config.Proxy.TLS.ClientSANsHeader = DefaultTLSClientSANsHeader
+	}
+
+	if config.Proxy.TLS.ClientFingerprintHeader == "" {
+		config.Proxy.TLS.ClientFingerprintHeader = DefaultTLSClientFingerprintHeader
+	}
+
 	if config.Proxy.Transport.MaxIdleConnections == 0 {
 		config.Proxy.Transport.MaxIdleConnections = DefaultTransportMaxIdleConnections
 	}
@@ -101,10 +390,62 @@ func (config *Config) SetDefaults() {
 		config.Proxy.TargetURL = DefaultUpstreamURL
 	}
 
+	if config.Cache.Backend == "" {
+		config.Cache.Backend = DefaultCacheBackend
+	}
+
+	if config.Cache.EvictionPolicy == "" {
+		config.Cache.EvictionPolicy = DefaultCacheEviction
+	}
+
+	if config.Cache.Shards == 0 {
+		config.Cache.Shards = DefaultCacheShards
+	}
+
+	if config.Cache.SweepInterval == 0 {
+		config.Cache.SweepInterval = DefaultCacheSweepInterval
+	}
+
+	if config.Cache.SweepLimit == 0 {
+		config.Cache.SweepLimit = DefaultCacheSweepLimit
+	}
+
 	if config.Cache.TTL == 0 {
 		config.Cache.TTL = DefaultCacheTTL
 	}
 
+	if config.Cache.NegativeTTL == 0 {
+		config.Cache.NegativeTTL = DefaultCacheNegativeTTL
+	}
+
+	if config.Cache.MemoryCheckInterval == 0 {
+		config.Cache.MemoryCheckInterval = DefaultCacheMemoryCheck
+	}
+
+	if config.Cache.RefreshMinHits == 0 {
+		config.Cache.RefreshMinHits = DefaultCacheRefreshMinHits
+	}
+
+	if config.Cache.RefreshConcurrency == 0 {
+		config.Cache.RefreshConcurrency = DefaultCacheRefreshConcurrency
+	}
+
+	if config.Cache.RefreshTimeout == 0 {
+		config.Cache.RefreshTimeout = DefaultCacheRefreshTimeout
+	}
+
+	if config.Cache.CompressionMinSize == 0 {
+		config.Cache.CompressionMinSize = DefaultCacheCompressionMinSize
+	}
+
+	if config.Cache.CompressionTypes == nil {
+		config.Cache.CompressionTypes = DefaultCacheCompressionTypes()
+	}
+
+	if config.Cache.SnapshotInterval == 0 {
+		config.Cache.SnapshotInterval = DefaultCacheSnapshotInterval
+	}
+
 	if config.Cache.MaxSize == 0 {
 		config.Cache.MaxSize = DefaultMaxCacheSize
 	}
@@ -112,4 +453,136 @@ func (config *Config) SetDefaults() {
 	if config.Cache.MaxRecordSize == 0 {
 		config.Cache.MaxRecordSize = DefaultMaxCacheRecordSize
 	}
+
+	if config.Cache.StatusName == "" {
+		config.Cache.StatusName = DefaultCacheStatusName
+	}
+
+	if config.Cache.Disk.Dir == "" {
+		config.Cache.Disk.Dir = filepath.Join(os.TempDir(), DefaultDiskCacheDirName)
+	}
+
+	if config.Cache.Disk.MaxSize == 0 {
+		config.Cache.Disk.MaxSize = DefaultDiskCacheMaxSize
+	}
+
+	if config.Cache.Disk.MaxRecordSize == 0 {
+		config.Cache.Disk.MaxRecordSize = DefaultDiskCacheMaxRecordSize
+	}
+
+	if config.Cache.Redis.Addr == "" {
+		config.Cache.Redis.Addr = DefaultRedisAddr
+	}
+
+	if config.Cache.Redis.KeyPrefix == "" {
+		config.Cache.Redis.KeyPrefix = DefaultRedisKeyPrefix
+	}
+
+	if config.Cache.Redis.PoolSize == 0 {
+		config.Cache.Redis.PoolSize = DefaultRedisPoolSize
+	}
+
+	if config.Cache.Redis.MaxRecordSize == 0 {
+		config.Cache.Redis.MaxRecordSize = DefaultRedisMaxRecordSize
+	}
+
+	if config.Cache.Redis.DialTimeout == 0 {
+		config.Cache.Redis.DialTimeout = DefaultRedisDialTimeout
+	}
+
+	if config.Cache.Redis.ReadTimeout == 0 {
+		config.Cache.Redis.ReadTimeout = DefaultRedisReadTimeout
+	}
+
+	if config.Cache.Redis.WriteTimeout == 0 {
+		config.Cache.Redis.WriteTimeout = DefaultRedisWriteTimeout
+	}
+
+	if config.Cache.Redis.RetryBackoff == 0 {
+		config.Cache.Redis.RetryBackoff = DefaultRedisRetryBackoff
+	}
+
+	if len(config.Cache.Memcached.Servers) == 0 {
+		config.Cache.Memcached.Servers = []string{DefaultMemcachedServer}
+	}
+
+	if config.Cache.Memcached.KeyPrefix == "" {
+		config.Cache.Memcached.KeyPrefix = DefaultMemcachedKeyPrefix
+	}
+
+	if config.Cache.Memcached.PoolSize == 0 {
+		config.Cache.Memcached.PoolSize = DefaultMemcachedPoolSize
+	}
+
+	if config.Cache.Memcached.MaxItemSize == 0 {
+		config.Cache.Memcached.MaxItemSize = DefaultMemcachedMaxItemSize
+	}
+
+	if config.Cache.Memcached.MaxRecordSize == 0 {
+		config.Cache.Memcached.MaxRecordSize = DefaultMemcachedMaxRecordSize
+	}
+
+	if config.Cache.Memcached.DialTimeout == 0 {
+		config.Cache.Memcached.DialTimeout = DefaultMemcachedDialTimeout
+	}
+
+	if config.Cache.Memcached.ReadTimeout == 0 {
+		config.Cache.Memcached.ReadTimeout = DefaultMemcachedReadTimeout
+	}
+
+	if config.Cache.Memcached.WriteTimeout == 0 {
+		config.Cache.Memcached.WriteTimeout = DefaultMemcachedWriteTimeout
+	}
+
+	if config.Cache.Memcached.RetryBackoff == 0 {
+		config.Cache.Memcached.RetryBackoff = DefaultMemcachedRetryBackoff
+	}
+
+	if config.Cache.Peers.ListenHost == "" {
+		config.Cache.Peers.ListenHost = DefaultPeerListenHost
+	}
+
+	if config.Cache.Peers.ListenPort == 0 {
+		config.Cache.Peers.ListenPort = DefaultPeerListenPort
+	}
+
+	if config.Cache.Peers.Self == "" {
+		config.Cache.Peers.Self = fmt.Sprintf("http://localhost:%d", config.Cache.Peers.ListenPort)
+	}
+
+	if config.Cache.Peers.RefreshInterval == 0 {
+		config.Cache.Peers.RefreshInterval = DefaultPeerRefreshInterval
+	}
+
+	if config.Cache.Peers.HotThreshold == 0 {
+		config.Cache.Peers.HotThreshold = DefaultPeerHotThreshold
+	}
+
+	if config.Cache.Peers.HotTTL == 0 {
+		config.Cache.Peers.HotTTL = DefaultPeerHotTTL
+	}
+
+	if config.Cache.Peers.Timeout == 0 {
+		config.Cache.Peers.Timeout = DefaultPeerTimeout
+	}
+
+	if config.Cache.Peers.RetryBackoff == 0 {
+		config.Cache.Peers.RetryBackoff = DefaultPeerRetryBackoff
+	}
+
+	if config.Cache.Warm.Concurrency == 0 {
+		config.Cache.Warm.Concurrency = DefaultWarmConcurrency
+	}
+
+	if config.Cache.Warm.Rate == 0 {
+		config.Cache.Warm.Rate = DefaultWarmRate
+	}
+
+	if config.Admin.ListenHost == "" {
+		config.Admin.ListenHost = DefaultAdminListenHost
+	}
+
+	if config.Admin.ListenPort == 0 {
+		config.Admin.ListenPort = DefaultAdminListenPort
+	}
 }
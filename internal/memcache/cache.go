@@ -1,9 +1,10 @@
 package memcache
 
 import (
-	"container/list"
 	"errors"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,90 +18,221 @@ type MemoryCache struct {
 	maxCacheSize      int
 	maxRecordSize     int
 	remainingCapacity int
+	stats             Stats
 
-	ll *list.List
+	policy  Policy
+	janitor *janitor
+	monitor *janitor
 }
 
 type Record struct {
 	StatusCode int //if in future need to cache other status codes
 	Body       []byte
 	Headers    http.Header
-	expiry     time.Time
-	size       int
+	// ContentEncoding is the encoding the cache compressed Body with, or
+	// empty if Body is kept as received.
+	ContentEncoding string
+	storedAt        time.Time
+	expiry          time.Time
+	size            int
+	hits            int64
+}
+
+// Stats is a point-in-time view of the cache counters.
+type Stats struct {
+	Hits             int64         `json:"hits"`
+	Misses           int64         `json:"misses"`
+	StaleHits        int64         `json:"staleHits"`
+	Evictions        EvictionStats `json:"evictions"`
+	RejectedTooLarge int64         `json:"rejectedTooLarge"`
+	Records          int           `json:"records"`
+	BytesUsed        int           `json:"bytesUsed"`
+	Capacity         int           `json:"capacity"`
+}
+
+// EvictionStats counts removed records by the reason they were removed.
+type EvictionStats struct {
+	// Capacity counts records evicted to make room, whichever policy chose
+	// them.
+	Capacity int64 `json:"capacity"`
+	Expired  int64 `json:"expired"`
+	Purged   int64 `json:"purged"`
+	// Memory counts records evicted because the process neared its memory limit.
+	Memory int64 `json:"memory"`
+}
+
+// EntryInfo describes a single cached record for introspection.
+type EntryInfo struct {
+	Key          string
+	StatusCode   int
+	Size         int
+	Age          time.Duration
+	TTLRemaining time.Duration
+	Hits         int64
+}
 
-	linkedlistEle *list.Element
+// EntryFilter selects and pages the records returned by Entries.
+type EntryFilter struct {
+	Prefix string
+	Offset int
+	Limit  int
 }
 
 func NewMemoryCache(ttl time.Duration, maxSize, maxRecordSize int) *MemoryCache {
+	return NewMemoryCacheWithPolicy(ttl, maxSize, maxRecordSize, NewLRUPolicy())
+}
+
+// NewMemoryCacheWithPolicy is like NewMemoryCache but evicts records chosen by policy.
+func NewMemoryCacheWithPolicy(ttl time.Duration, maxSize, maxRecordSize int, policy Policy) *MemoryCache {
 	return &MemoryCache{
 		records:           make(map[string]*Record),
 		ttl:               ttl,
 		maxCacheSize:      maxSize,
 		maxRecordSize:     maxRecordSize,
 		remainingCapacity: maxSize,
-		ll:                list.New(),
+		policy:            policy,
 	}
 }
 
+// Get returns a copy of the record stored under k, or nil if there is no
+// fresh record. Modifying the copy doesn't affect the cache.
 func (cache *MemoryCache) Get(k string) *Record {
+	r, _ := cache.Lookup(k)
+
+	return r
+}
+
+// Lookup is like Get but additionally reports whether an expired record was found
+// (and removed) for k.
+func (cache *MemoryCache) Lookup(k string) (*Record, bool) {
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
 
 	r, ok := cache.records[k]
 	if !ok {
-		return nil
+		cache.stats.Misses++
+		cache.mu.Unlock()
+
+		return nil, false
 	}
 
 	//If the record has expired, delete it from the cache
-	if time.Now().After(r.expiry) {
-		cache.remainingCapacity += r.size
-		cache.ll.Remove(r.linkedlistEle)
-		delete(cache.records, k)
+	if r.Expired(time.Now()) {
+		cache.stats.StaleHits++
+		cache.stats.Misses++
+		cache.stats.Evictions.Expired++
+		cache.remove(k, r)
+		cache.mu.Unlock()
 
-		return nil
+		return nil, true
 	}
 
-	cache.ll.MoveToFront(r.linkedlistEle)
+	cache.stats.Hits++
+	r.hits++
+	cache.policy.Hit(k)
+	cache.mu.Unlock()
 
-	return r
+	// Stored records are never modified, apart from their hit count, so the
+	// copy handed to the caller can be made without holding the lock.
+	return r.Clone(), false
 }
 
-func (cache *MemoryCache) Set(k string, data *Record) error {
+// Delete purges the record stored under k. It reports whether a record was removed.
+func (cache *MemoryCache) Delete(k string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	r, ok := cache.records[k]
+	if !ok {
+		return false
+	}
+
+	cache.stats.Evictions.Purged++
+	cache.remove(k, r)
+
+	return true
+}
+
+// Purge removes every record from the cache and returns the number removed.
+func (cache *MemoryCache) Purge() int {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
-	data.size = data.Calsize()
+	n := len(cache.records)
+
+	cache.stats.Evictions.Purged += int64(n)
+	cache.records = make(map[string]*Record)
+	cache.policy.Reset()
+	cache.remainingCapacity = cache.maxCacheSize
+
+	return n
+}
+
+func (cache *MemoryCache) remove(k string, r *Record) {
+	cache.policy.Remove(k)
+	cache.forget(k, r)
+}
+
+// forget drops k without notifying the policy, which already stopped tracking it.
+func (cache *MemoryCache) forget(k string, r *Record) {
+	cache.remainingCapacity += r.size
+	delete(cache.records, k)
+}
+
+func (cache *MemoryCache) Set(k string, data *Record) error {
+	return cache.SetWithTTL(k, data, cache.ttl)
+}
+
+// SetWithTTL is like Set but overrides the cache-wide TTL for this record.
+func (cache *MemoryCache) SetWithTTL(k string, data *Record, ttl time.Duration) error {
+	data.Stamp(time.Now(), ttl)
+
+	return cache.insert(k, data)
+}
+
+// Restore stores data under k keeping the stored and expiry times it already
+// carries. Records that have already expired are dropped.
+func (cache *MemoryCache) Restore(k string, data *Record) error {
+	if data.Expired(time.Now()) {
+		return nil
+	}
+
+	return cache.insert(k, data)
+}
+
+// insert stores a copy of data, so that the caller can't modify the stored record.
+func (cache *MemoryCache) insert(k string, data *Record) error {
+	data = data.Clone()
+	data.size = entrySize(k, data)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
 
 	// Not caching request if it exceeds maxRequestSize limit
 	if data.size > cache.maxRecordSize {
+		cache.stats.RejectedTooLarge++
+
 		return ErrMaxRecordSizeExceed
 	}
 
 	existingRecord, ok := cache.records[k]
 	if ok {
-		oldSize := existingRecord.size
-		cache.remainingCapacity += oldSize
-
-		cache.ll.Remove(existingRecord.linkedlistEle)
+		cache.remove(k, existingRecord)
 	}
 
-	// Keep deleting old entry in cache as cache has reached it's limit
-	for data.size > cache.remainingCapacity && cache.ll.Len() != 0 {
-		oldest := cache.ll.Back()
-		oldestRecordKey := oldest.Value.(string)
-
-		oldestRecord := cache.records[oldestRecordKey]
-		cache.remainingCapacity += oldestRecord.size
-		delete(cache.records, oldestRecordKey)
+	// Keep evicting entries chosen by the policy as cache has reached it's limit
+	for data.size > cache.remainingCapacity {
+		victim, ok := cache.policy.Evict()
+		if !ok {
+			break
+		}
 
-		cache.ll.Remove(oldest)
+		cache.stats.Evictions.Capacity++
+		cache.forget(victim, cache.records[victim])
 	}
 
 	cache.remainingCapacity -= data.size
 
-	data.expiry = time.Now().Add(cache.ttl)
-
-	data.linkedlistEle = cache.ll.PushFront(k)
+	cache.policy.Add(k)
 
 	cache.records[k] = data
 
@@ -114,16 +246,169 @@ func (cache *MemoryCache) Count() int {
 	return len(cache.records)
 }
 
-func (r *Record) Calsize() int {
-	size := 40 // accounting for 2 int variables and one time.Time field
+// Sweep removes expired records, examining at most limit records. As map
+// iteration order is random, successive sweeps examine different records.
+// It returns the number of records removed.
+func (cache *MemoryCache) Sweep(limit int) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	examined, removed := 0, 0
+
+	for k, r := range cache.records {
+		if examined == limit {
+			break
+		}
+
+		examined++
+
+		if r.Expired(now) {
+			cache.stats.Evictions.Expired++
+			cache.remove(k, r)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// StartJanitor removes expired records in the background every interval,
+// examining at most limit records per run, until Close is called.
+func (cache *MemoryCache) StartJanitor(interval time.Duration, limit int) {
+	cache.janitor = startSweeper(interval, func() int {
+		return cache.Sweep(limit)
+	})
+}
+
+// Shrink evicts records chosen by the policy until at least n bytes are
+// freed or the cache is empty, and returns the number of bytes freed.
+func (cache *MemoryCache) Shrink(n int) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
 
-	for k, vals := range r.Headers {
-		size += len(k)
-		for _, v := range vals {
-			size += len(v)
+	freed := 0
+	for freed < n {
+		victim, ok := cache.policy.Evict()
+		if !ok {
+			break
 		}
+
+		r := cache.records[victim]
+		freed += r.size
+
+		cache.stats.Evictions.Memory++
+		cache.forget(victim, r)
+	}
+
+	return freed
+}
+
+// StartMemoryMonitor checks the memory use of the process every interval
+// and shrinks the cache when it nears limit, until Close is called.
+func (cache *MemoryCache) StartMemoryMonitor(interval time.Duration, limit int64) {
+	cache.monitor = startMemoryMonitor(interval, limit, cache.Shrink)
+}
+
+// Close stops the janitor and the memory monitor, if started.
+func (cache *MemoryCache) Close() error {
+	if cache.janitor != nil {
+		cache.janitor.Close()
 	}
-	size += len(r.Body)
 
-	return size
+	if cache.monitor != nil {
+		cache.monitor.Close()
+	}
+
+	return nil
+}
+
+// Stats returns a copy of the cache counters.
+func (cache *MemoryCache) Stats() Stats {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	stats := cache.stats
+	stats.Records = len(cache.records)
+	stats.BytesUsed = cache.maxCacheSize - cache.remainingCapacity
+	stats.Capacity = cache.maxCacheSize
+
+	return stats
+}
+
+// Entries lists the cached records sorted by key, filtered and paged by f.
+// The second return value is the number of records matching the filter before paging.
+func (cache *MemoryCache) Entries(f EntryFilter) ([]EntryInfo, int) {
+	cache.mu.RLock()
+
+	now := time.Now()
+	entries := make([]EntryInfo, 0, len(cache.records))
+
+	for k, r := range cache.records {
+		if !strings.HasPrefix(k, f.Prefix) {
+			continue
+		}
+
+		entries = append(entries, EntryInfo{
+			Key:          k,
+			StatusCode:   r.StatusCode,
+			Size:         r.size,
+			Age:          r.Age(now),
+			TTLRemaining: r.TTL(now),
+			Hits:         r.hits,
+		})
+	}
+
+	cache.mu.RUnlock()
+
+	return PageEntries(entries, f)
+}
+
+// PageEntries sorts entries by key and returns the page selected by f along
+// with the number of entries before paging.
+func PageEntries(entries []EntryInfo, f EntryFilter) ([]EntryInfo, int) {
+	slices.SortFunc(entries, func(a, b EntryInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	total := len(entries)
+
+	start := min(max(f.Offset, 0), total)
+	end := total
+	if f.Limit > 0 {
+		end = min(start+f.Limit, total)
+	}
+
+	return entries[start:end], total
+}
+
+// Age returns how long ago the record was stored.
+func (r *Record) Age(now time.Time) time.Duration {
+	return max(now.Sub(r.storedAt), 0)
+}
+
+// TTL returns the time left before the record expires.
+func (r *Record) TTL(now time.Time) time.Duration {
+	return max(r.expiry.Sub(now), 0)
+}
+
+// Clone returns a deep copy of r, sharing no memory with it. The body is
+// copied into a slice of exactly its length, dropping any spare capacity.
+// The hit count is not copied.
+func (r *Record) Clone() *Record {
+	var body []byte
+	if r.Body != nil {
+		body = make([]byte, len(r.Body))
+		copy(body, r.Body)
+	}
+
+	return &Record{
+		StatusCode:      r.StatusCode,
+		Body:            body,
+		Headers:         r.Headers.Clone(),
+		ContentEncoding: r.ContentEncoding,
+		storedAt:        r.storedAt,
+		expiry:          r.expiry,
+		size:            r.size,
+	}
 }
@@ -1,6 +1,9 @@
 package memcache
 
 import (
+	"encoding/binary"
+	"net/http"
+	"strconv"
 	"testing"
 	"time"
 
@@ -10,21 +13,24 @@ import (
 func TestCacheMaxSize(t *testing.T) {
 	eval := is.New(t)
 
-	c := NewMemoryCache(30*time.Second, 100, 100)
+	// size of a record with an empty body stored under a one byte key
+	o := entrySize("1", &Record{})
+
+	c := NewMemoryCache(30*time.Second, 2*o+20, 2*o+20)
 
 	err := c.Set("1", &Record{
 		Body: []byte("12345"),
 	})
 	eval.NoErr(err)
 	eval.Equal(c.Count(), 1)
-	eval.Equal(c.remainingCapacity, 55)
+	eval.Equal(c.remainingCapacity, o+15)
 
 	err = c.Set("1", &Record{
 		Body: []byte("123456"),
 	})
 	eval.NoErr(err)
 	eval.Equal(c.Count(), 1)
-	eval.Equal(c.remainingCapacity, 54)
+	eval.Equal(c.remainingCapacity, o+14)
 
 	err = c.Set("2", &Record{
 		Body: []byte("1234"),
@@ -44,7 +50,7 @@ func TestCacheMaxSize(t *testing.T) {
 	eval.Equal(c.remainingCapacity, 10)
 
 	err = c.Set("4", &Record{
-		Body: make([]byte, 50),
+		Body: make([]byte, o+10),
 	})
 	eval.NoErr(err)
 
@@ -55,17 +61,276 @@ func TestCacheMaxSize(t *testing.T) {
 func TestCacheMaxRecordSize(t *testing.T) {
 	eval := is.New(t)
 
-	c := NewMemoryCache(30*time.Second, 100, 10)
+	o := entrySize("1", &Record{})
+
+	c := NewMemoryCache(30*time.Second, 1000, o+9)
 
 	err := c.Set("1", &Record{
 		Body: []byte("1234567890"),
 	})
 	eval.Equal(err, ErrMaxRecordSizeExceed)
 
-	c = NewMemoryCache(30*time.Second, 100, 100)
+	c = NewMemoryCache(30*time.Second, 1000, o+10)
 
 	err = c.Set("1", &Record{
 		Body: []byte("1234567890"),
 	})
 	eval.NoErr(err)
 }
+
+func TestRecordSize(t *testing.T) {
+	eval := is.New(t)
+
+	empty := (&Record{}).Calsize()
+
+	// the key and the cache's bookkeeping are charged
+	eval.True(entrySize("key", &Record{}) > empty+len("key"))
+	eval.Equal(entrySize("longer-key", &Record{})-entrySize("key", &Record{}), len("longer-key")-len("key"))
+
+	// the body is counted by capacity
+	eval.Equal((&Record{Body: make([]byte, 10, 100)}).Calsize(), empty+100)
+
+	// every header and value costs more than its length
+	h := &Record{Headers: http.Header{"Vary": {"Accept", "Accept-Encoding"}}}
+	eval.True(h.Calsize() > empty+len("Vary")+len("Accept")+len("Accept-Encoding"))
+
+	one := &Record{Headers: http.Header{"Vary": {"Accept"}}}
+	eval.True(h.Calsize()-one.Calsize() > len("Accept-Encoding"))
+
+	// stored records don't keep the spare capacity of the caller's body
+	c := NewMemoryCache(time.Minute, 10000, 10000)
+	eval.NoErr(c.Set("k", &Record{Body: make([]byte, 10, 1000)}))
+	eval.Equal(c.Stats().BytesUsed, entrySize("k", &Record{Body: make([]byte, 10)}))
+}
+
+func TestCacheStats(t *testing.T) {
+	eval := is.New(t)
+
+	o := entrySize("1", &Record{})
+
+	c := NewMemoryCache(30*time.Second, 2*o+20, o+10)
+
+	eval.NoErr(c.Set("1", &Record{Body: []byte("12345")}))
+	eval.NoErr(c.Set("2", &Record{Body: []byte("12345")}))
+
+	eval.True(c.Get("1") != nil)
+	eval.True(c.Get("missing") == nil)
+
+	// "2" is the least recently used record and gets evicted
+	eval.NoErr(c.Set("3", &Record{Body: []byte("1234567")}))
+
+	err := c.Set("4", &Record{Body: make([]byte, 20)})
+	eval.Equal(err, ErrMaxRecordSizeExceed)
+
+	eval.True(c.Delete("3"))
+	eval.True(!c.Delete("3"))
+
+	stats := c.Stats()
+	eval.Equal(stats.Hits, int64(1))
+	eval.Equal(stats.Misses, int64(1))
+	eval.Equal(stats.Evictions.Capacity, int64(1))
+	eval.Equal(stats.Evictions.Purged, int64(1))
+	eval.Equal(stats.RejectedTooLarge, int64(1))
+	eval.Equal(stats.Records, 1)
+	eval.Equal(stats.BytesUsed, o+5)
+	eval.Equal(stats.Capacity, 2*o+20)
+}
+
+func TestCacheShrink(t *testing.T) {
+	eval := is.New(t)
+
+	o := entrySize("1", &Record{})
+
+	c := NewMemoryCache(time.Minute, 10*o, o)
+
+	for i := range 5 {
+		eval.NoErr(c.Set(strconv.Itoa(i), &Record{}))
+	}
+
+	// the least recently used records go first
+	c.Get("0")
+	eval.Equal(c.Shrink(o+1), 2*o)
+	eval.True(c.Get("0") != nil)
+	eval.True(c.Get("1") == nil)
+	eval.True(c.Get("2") == nil)
+
+	eval.Equal(c.Shrink(100*o), 3*o)
+	eval.Equal(c.Count(), 0)
+	eval.Equal(c.Stats().Evictions.Memory, int64(5))
+	eval.Equal(c.Stats().BytesUsed, 0)
+}
+
+func TestMemoryPressure(t *testing.T) {
+	eval := is.New(t)
+
+	eval.Equal(memoryPressure(800, 1000), int64(0))
+	eval.Equal(memoryPressure(899, 1000), int64(0))
+	eval.Equal(memoryPressure(950, 1000), int64(150))
+	eval.Equal(memoryPressure(1200, 1000), int64(400))
+}
+
+func TestCacheStaleHit(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewMemoryCache(10*time.Millisecond, 1000, 1000)
+
+	eval.NoErr(c.Set("1", &Record{Body: []byte("12345")}))
+
+	time.Sleep(20 * time.Millisecond)
+
+	eval.True(c.Get("1") == nil)
+
+	stats := c.Stats()
+	eval.Equal(stats.StaleHits, int64(1))
+	eval.Equal(stats.Misses, int64(1))
+	eval.Equal(stats.Evictions.Expired, int64(1))
+	eval.Equal(stats.BytesUsed, 0)
+}
+
+func TestCacheEntries(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewMemoryCache(30*time.Second, 10000, 1000)
+
+	for _, k := range []string{"GET:/c", "GET:/a", "HEAD:/a", "GET:/b"} {
+		eval.NoErr(c.Set(k, &Record{StatusCode: 200, Body: []byte("12345")}))
+	}
+
+	c.Get("GET:/b")
+	c.Get("GET:/b")
+
+	entries, total := c.Entries(EntryFilter{Prefix: "GET:", Offset: 1, Limit: 1})
+	eval.Equal(total, 3)
+	eval.Equal(len(entries), 1)
+	eval.Equal(entries[0].Key, "GET:/b")
+	eval.Equal(entries[0].Hits, int64(2))
+	eval.Equal(entries[0].Size, entrySize("GET:/b", &Record{})+5)
+	eval.True(entries[0].TTLRemaining > 29*time.Second)
+
+	entries, total = c.Entries(EntryFilter{Offset: 10})
+	eval.Equal(total, 4)
+	eval.Equal(len(entries), 0)
+}
+
+func TestRecordBinaryEncoding(t *testing.T) {
+	eval := is.New(t)
+
+	r := Record{
+		StatusCode:      200,
+		Body:            []byte("body"),
+		Headers:         http.Header{"Content-Type": {"text/plain"}, "Vary": {"Accept", "Accept-Encoding"}},
+		ContentEncoding: "gzip",
+	}
+	r.Stamp(time.Unix(100, 5), time.Minute)
+
+	data, err := r.MarshalBinary()
+	eval.NoErr(err)
+
+	var got Record
+	eval.NoErr(got.UnmarshalBinary(data))
+	eval.Equal(got.StatusCode, r.StatusCode)
+	eval.Equal(got.Body, r.Body)
+	eval.Equal(got.Headers, r.Headers)
+	eval.True(got.StoredAt().Equal(r.StoredAt()))
+	eval.True(got.ExpiresAt().Equal(r.ExpiresAt()))
+	eval.Equal(got.ContentEncoding, "gzip")
+
+	// version 1 records have no content encoding
+	v1 := []byte{1}
+	v1 = binary.AppendUvarint(v1, 200)
+	v1 = binary.AppendVarint(v1, 100)
+	v1 = binary.AppendVarint(v1, 200)
+	v1 = binary.AppendUvarint(v1, 0)
+	v1 = appendBytes(v1, []byte("old"))
+
+	eval.NoErr(got.UnmarshalBinary(v1))
+	eval.Equal(got.StatusCode, 200)
+	eval.Equal(got.Body, []byte("old"))
+	eval.Equal(got.ContentEncoding, "")
+	eval.True(got.ExpiresAt().Equal(time.Unix(0, 200)))
+
+	eval.Equal(got.UnmarshalBinary(data[:len(data)-1]), ErrInvalidRecord)
+	eval.Equal(got.UnmarshalBinary(nil), ErrInvalidRecord)
+}
+
+func TestCacheSweep(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewMemoryCache(10*time.Millisecond, 10000, 1000)
+
+	for i := range 10 {
+		eval.NoErr(c.Set(strconv.Itoa(i), &Record{}))
+	}
+
+	eval.NoErr(c.SetWithTTL("live", &Record{}, time.Minute))
+
+	time.Sleep(20 * time.Millisecond)
+
+	// bounded work per sweep
+	eval.Equal(c.Sweep(0), 0)
+	eval.True(c.Sweep(5) <= 5)
+
+	c.Sweep(100)
+
+	eval.Equal(c.Count(), 1)
+	eval.Equal(c.Stats().Evictions.Expired, int64(10))
+	eval.Equal(c.Stats().BytesUsed, entrySize("live", &Record{}))
+}
+
+func TestCacheJanitor(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewShardedMemoryCache(5*time.Millisecond, 16*1024, 1024, 4, func() Policy { return NewLRUPolicy() })
+	c.StartJanitor(5*time.Millisecond, 100)
+
+	for i := range 20 {
+		eval.NoErr(c.Set(strconv.Itoa(i), &Record{}))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.Count() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	eval.Equal(c.Count(), 0)
+	eval.NoErr(c.Close())
+	eval.NoErr(c.Close())
+
+	// nothing is removed once closed
+	eval.NoErr(c.Set("k", &Record{}))
+	time.Sleep(20 * time.Millisecond)
+	eval.Equal(c.Count(), 1)
+}
+
+func TestCacheRecordsAreNotShared(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewMemoryCache(time.Minute, 1024, 1024)
+
+	r := &Record{
+		StatusCode: http.StatusOK,
+		Body:       []byte("hello"),
+		Headers:    http.Header{"Content-Type": []string{"text/plain"}},
+	}
+	eval.NoErr(c.Set("k", r))
+
+	// modifying the record after storing it doesn't change the cache
+	r.Body[0] = 'j'
+	r.Headers.Set("Content-Type", "text/html")
+	r.Headers.Add("X-Extra", "1")
+
+	got := c.Get("k")
+	eval.Equal(string(got.Body), "hello")
+	eval.Equal(got.Headers.Get("Content-Type"), "text/plain")
+	eval.Equal(got.Headers.Get("X-Extra"), "")
+
+	// neither does modifying a returned record
+	got.Body[0] = 'y'
+	got.Headers.Set("Content-Type", "text/html")
+	got.StatusCode = http.StatusNotFound
+
+	got = c.Get("k")
+	eval.Equal(string(got.Body), "hello")
+	eval.Equal(got.Headers.Get("Content-Type"), "text/plain")
+	eval.Equal(got.StatusCode, http.StatusOK)
+}
@@ -0,0 +1,195 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// recordEncodingVersion is the version written by MarshalBinary. Version 1
+// records, which lack the content encoding, are still decoded.
+const recordEncodingVersion = 2
+
+var ErrInvalidRecord = errors.New("invalid record encoding")
+
+// Stamp sets the time r was stored and when it expires.
+func (r *Record) Stamp(storedAt time.Time, ttl time.Duration) {
+	r.storedAt = storedAt
+	r.expiry = storedAt.Add(ttl)
+}
+
+func (r *Record) StoredAt() time.Time {
+	return r.storedAt
+}
+
+func (r *Record) ExpiresAt() time.Time {
+	return r.expiry
+}
+
+// Expired reports whether r is no longer fresh at now.
+func (r *Record) Expired(now time.Time) bool {
+	return now.After(r.expiry)
+}
+
+// MarshalBinary encodes the record, including its stored and expiry times,
+// so that it can be kept outside of the process.
+func (r *Record) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, r.Calsize()+64)
+
+	b = append(b, recordEncodingVersion)
+	b = binary.AppendUvarint(b, uint64(r.StatusCode))
+	b = appendBytes(b, []byte(r.ContentEncoding))
+	b = binary.AppendVarint(b, r.storedAt.UnixNano())
+	b = binary.AppendVarint(b, r.expiry.UnixNano())
+
+	b = binary.AppendUvarint(b, uint64(len(r.Headers)))
+	for k, vals := range r.Headers {
+		b = appendBytes(b, []byte(k))
+		b = binary.AppendUvarint(b, uint64(len(vals)))
+
+		for _, v := range vals {
+			b = appendBytes(b, []byte(v))
+		}
+	}
+
+	b = appendBytes(b, r.Body)
+
+	return b, nil
+}
+
+func (r *Record) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+
+	version := d.byte()
+	if version != 1 && version != recordEncodingVersion {
+		return ErrInvalidRecord
+	}
+
+	status := d.uvarint()
+
+	var encoding []byte
+	if version >= 2 {
+		encoding = d.bytes()
+	}
+
+	storedAt := d.varint()
+	expiry := d.varint()
+
+	n := d.uvarint()
+	if n > uint64(len(data)) {
+		return ErrInvalidRecord
+	}
+
+	headers := make(http.Header, n)
+	for range n {
+		k := string(d.bytes())
+
+		nvals := d.uvarint()
+		if nvals > uint64(len(data)) {
+			return ErrInvalidRecord
+		}
+
+		vals := make([]string, 0, nvals)
+		for range nvals {
+			vals = append(vals, string(d.bytes()))
+		}
+
+		headers[k] = vals
+	}
+
+	body := d.bytes()
+
+	if d.err != nil || len(d.buf) != 0 {
+		return ErrInvalidRecord
+	}
+
+	*r = Record{
+		StatusCode:      int(status),
+		Headers:         headers,
+		Body:            body,
+		ContentEncoding: string(encoding),
+		storedAt:        time.Unix(0, storedAt),
+		expiry:          time.Unix(0, expiry),
+	}
+
+	return nil
+}
+
+func appendBytes(b, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+
+	return append(b, v...)
+}
+
+// decoder reads the fields written by MarshalBinary, remembering the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) == 0 {
+		d.err = ErrInvalidRecord
+
+		return 0
+	}
+
+	v := d.buf[0]
+	d.buf = d.buf[1:]
+
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrInvalidRecord
+
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrInvalidRecord
+
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+
+	if n > uint64(len(d.buf)) {
+		d.err = ErrInvalidRecord
+
+		return nil
+	}
+
+	v := make([]byte, n)
+	copy(v, d.buf)
+	d.buf = d.buf[n:]
+
+	return v
+}
@@ -0,0 +1,57 @@
+package memcache
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// janitor runs a maintenance task periodically until it is closed.
+type janitor struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func startJanitor(interval time.Duration, task func()) *janitor {
+	j := &janitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				task()
+			}
+		}
+	}()
+
+	return j
+}
+
+// Close stops the janitor and waits for a running task to finish.
+func (j *janitor) Close() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+
+	<-j.done
+}
+
+// startSweeper starts a janitor that removes expired records with sweep.
+func startSweeper(interval time.Duration, sweep func() int) *janitor {
+	return startJanitor(interval, func() {
+		if n := sweep(); n > 0 {
+			slog.Debug("Removed expired cache records", "records", n)
+		}
+	})
+}
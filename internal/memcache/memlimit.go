@@ -0,0 +1,63 @@
+package memcache
+
+import (
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// The memory monitor starts shrinking the cache once the process uses
+// pressureHighWater percent of the memory limit, and frees enough to bring
+// it back to pressureLowWater percent.
+const (
+	pressureHighWater = 90
+	pressureLowWater  = 80
+)
+
+// MemoryLimit returns the Go runtime memory limit, as set by GOMEMLIMIT or
+// debug.SetMemoryLimit, or 0 if there is none.
+func MemoryLimit() int64 {
+	limit := debug.SetMemoryLimit(-1)
+	if limit == math.MaxInt64 {
+		return 0
+	}
+
+	return limit
+}
+
+// memoryInUse returns the memory the runtime counts against its memory limit.
+func memoryInUse() int64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+// memoryPressure returns how many bytes should be freed when inUse is above
+// the high watermark of limit, or 0 otherwise.
+func memoryPressure(inUse, limit int64) int64 {
+	if inUse < limit/100*pressureHighWater {
+		return 0
+	}
+
+	return inUse - limit/100*pressureLowWater
+}
+
+func startMemoryMonitor(interval time.Duration, limit int64, shrink func(n int) int) *janitor {
+	return startJanitor(interval, func() {
+		inUse := memoryInUse()
+
+		n := memoryPressure(inUse, limit)
+		if n == 0 {
+			return
+		}
+
+		freed := shrink(int(n))
+		slog.Warn("Evicted cache records under memory pressure", "inUse", inUse, "limit", limit, "freed", freed)
+	})
+}
@@ -0,0 +1,444 @@
+package memcache
+
+import (
+	"container/list"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+const (
+	PolicyLRU      = "lru"
+	PolicyLFU      = "lfu"
+	PolicyARC      = "arc"
+	PolicyWTinyLFU = "wtinylfu"
+)
+
+// Policy decides which record MemoryCache evicts when it runs out of capacity.
+// Policies track keys only; the cache calls them while holding its lock.
+type Policy interface {
+	// Add is called when key is inserted.
+	Add(key string)
+	// Hit is called when key is read from the cache.
+	Hit(key string)
+	// Remove is called when key leaves the cache other than through Evict.
+	Remove(key string)
+	// Evict chooses a key to evict and stops tracking it. It returns false
+	// when no key is tracked.
+	Evict() (string, bool)
+	// Reset stops tracking every key.
+	Reset()
+	// Keys returns the tracked keys from the first to the last to be evicted.
+	// Policies that weigh frequency as well as recency order them approximately.
+	Keys() []string
+}
+
+// NewPolicy returns the eviction policy called name.
+func NewPolicy(name string) (Policy, error) {
+	switch name {
+	case PolicyLRU, "":
+		return NewLRUPolicy(), nil
+	case PolicyLFU:
+		return NewLFUPolicy(), nil
+	case PolicyARC:
+		return NewARCPolicy(), nil
+	case PolicyWTinyLFU:
+		return NewWTinyLFUPolicy(), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", name)
+	}
+}
+
+// orderedKeys is a recency ordered set of keys. The front is the most recently used key.
+type orderedKeys struct {
+	ll   *list.List
+	keys map[string]*list.Element
+}
+
+func newOrderedKeys() *orderedKeys {
+	return &orderedKeys{
+		ll:   list.New(),
+		keys: make(map[string]*list.Element),
+	}
+}
+
+func (o *orderedKeys) Len() int {
+	return o.ll.Len()
+}
+
+func (o *orderedKeys) Contains(key string) bool {
+	_, ok := o.keys[key]
+
+	return ok
+}
+
+func (o *orderedKeys) PushFront(key string) {
+	o.keys[key] = o.ll.PushFront(key)
+}
+
+func (o *orderedKeys) MoveToFront(key string) {
+	if e, ok := o.keys[key]; ok {
+		o.ll.MoveToFront(e)
+	}
+}
+
+func (o *orderedKeys) Remove(key string) bool {
+	e, ok := o.keys[key]
+	if !ok {
+		return false
+	}
+
+	o.ll.Remove(e)
+	delete(o.keys, key)
+
+	return true
+}
+
+// Back returns the least recently used key.
+func (o *orderedKeys) Back() (string, bool) {
+	e := o.ll.Back()
+	if e == nil {
+		return "", false
+	}
+
+	return e.Value.(string), true
+}
+
+func (o *orderedKeys) PopBack() (string, bool) {
+	key, ok := o.Back()
+	if ok {
+		o.Remove(key)
+	}
+
+	return key, ok
+}
+
+func (o *orderedKeys) Reset() {
+	o.ll.Init()
+	clear(o.keys)
+}
+
+// AppendKeys appends the keys to keys from the least to the most recently used.
+func (o *orderedKeys) AppendKeys(keys []string) []string {
+	for e := o.ll.Back(); e != nil; e = e.Prev() {
+		keys = append(keys, e.Value.(string))
+	}
+
+	return keys
+}
+
+// LRUPolicy evicts the least recently used key.
+type LRUPolicy struct {
+	keys *orderedKeys
+}
+
+func NewLRUPolicy() *LRUPolicy {
+	return &LRUPolicy{keys: newOrderedKeys()}
+}
+
+func (p *LRUPolicy) Add(key string)        { p.keys.PushFront(key) }
+func (p *LRUPolicy) Hit(key string)        { p.keys.MoveToFront(key) }
+func (p *LRUPolicy) Remove(key string)     { p.keys.Remove(key) }
+func (p *LRUPolicy) Evict() (string, bool) { return p.keys.PopBack() }
+func (p *LRUPolicy) Reset()                { p.keys.Reset() }
+func (p *LRUPolicy) Keys() []string        { return p.keys.AppendKeys(nil) }
+
+// LFUPolicy evicts the least frequently used key, and the least recently used
+// one among keys with the same frequency.
+type LFUPolicy struct {
+	freq    map[string]int
+	buckets map[int]*orderedKeys
+	minFreq int
+}
+
+func NewLFUPolicy() *LFUPolicy {
+	return &LFUPolicy{
+		freq:    make(map[string]int),
+		buckets: make(map[int]*orderedKeys),
+	}
+}
+
+func (p *LFUPolicy) Add(key string) {
+	p.freq[key] = 1
+	p.bucket(1).PushFront(key)
+	p.minFreq = 1
+}
+
+func (p *LFUPolicy) Hit(key string) {
+	f, ok := p.freq[key]
+	if !ok {
+		return
+	}
+
+	p.buckets[f].Remove(key)
+	p.freq[key] = f + 1
+	p.bucket(f + 1).PushFront(key)
+}
+
+func (p *LFUPolicy) Remove(key string) {
+	if f, ok := p.freq[key]; ok {
+		p.buckets[f].Remove(key)
+		delete(p.freq, key)
+	}
+}
+
+func (p *LFUPolicy) Evict() (string, bool) {
+	if len(p.freq) == 0 {
+		return "", false
+	}
+
+	// minFreq is a lower bound; Hit and Remove don't maintain it.
+	for ; ; p.minFreq++ {
+		if b, ok := p.buckets[p.minFreq]; ok && b.Len() > 0 {
+			key, _ := b.PopBack()
+			delete(p.freq, key)
+
+			if b.Len() == 0 {
+				delete(p.buckets, p.minFreq)
+			}
+
+			return key, true
+		}
+
+		delete(p.buckets, p.minFreq)
+	}
+}
+
+func (p *LFUPolicy) Reset() {
+	clear(p.freq)
+	clear(p.buckets)
+	p.minFreq = 0
+}
+
+func (p *LFUPolicy) Keys() []string {
+	keys := make([]string, 0, len(p.freq))
+	for _, f := range slices.Sorted(maps.Keys(p.buckets)) {
+		keys = p.buckets[f].AppendKeys(keys)
+	}
+
+	return keys
+}
+
+func (p *LFUPolicy) bucket(f int) *orderedKeys {
+	b, ok := p.buckets[f]
+	if !ok {
+		b = newOrderedKeys()
+		p.buckets[f] = b
+	}
+
+	return b
+}
+
+// ARCPolicy implements Adaptive Replacement Cache. Resident keys seen once
+// are kept in t1 and keys seen more than once in t2; b1 and b2 remember keys
+// recently evicted from them. A re-inserted key found in b1 grows the share
+// of t1, one found in b2 grows the share of t2.
+//
+// As MemoryCache is bounded by bytes rather than entries, the number of
+// resident keys is used as the cache size.
+type ARCPolicy struct {
+	t1, t2, b1, b2 *orderedKeys
+	// p is the target size of t1.
+	p int
+}
+
+func NewARCPolicy() *ARCPolicy {
+	return &ARCPolicy{
+		t1: newOrderedKeys(),
+		t2: newOrderedKeys(),
+		b1: newOrderedKeys(),
+		b2: newOrderedKeys(),
+	}
+}
+
+func (p *ARCPolicy) Add(key string) {
+	c := max(p.t1.Len()+p.t2.Len(), 1)
+
+	switch {
+	case p.b1.Remove(key):
+		p.p = min(p.p+max(p.b2.Len()/max(p.b1.Len(), 1), 1), c)
+		p.t2.PushFront(key)
+	case p.b2.Remove(key):
+		p.p = max(p.p-max(p.b1.Len()/max(p.b2.Len(), 1), 1), 0)
+		p.t2.PushFront(key)
+	default:
+		p.t1.PushFront(key)
+	}
+}
+
+func (p *ARCPolicy) Hit(key string) {
+	if p.t1.Remove(key) {
+		p.t2.PushFront(key)
+
+		return
+	}
+
+	p.t2.MoveToFront(key)
+}
+
+func (p *ARCPolicy) Remove(key string) {
+	if !p.t1.Remove(key) {
+		p.t2.Remove(key)
+	}
+}
+
+func (p *ARCPolicy) Evict() (string, bool) {
+	var (
+		key   string
+		ok    bool
+		ghost *orderedKeys
+	)
+
+	if p.t1.Len() > 0 && (p.t1.Len() > p.p || p.t2.Len() == 0) {
+		key, ok = p.t1.PopBack()
+		ghost = p.b1
+	} else {
+		key, ok = p.t2.PopBack()
+		ghost = p.b2
+	}
+
+	if !ok {
+		return "", false
+	}
+
+	ghost.PushFront(key)
+
+	// Ghost lists remember at most as many keys as are resident.
+	c := max(p.t1.Len()+p.t2.Len(), 1)
+	for p.b1.Len() > c {
+		p.b1.PopBack()
+	}
+
+	for p.b2.Len() > c {
+		p.b2.PopBack()
+	}
+
+	return key, true
+}
+
+func (p *ARCPolicy) Keys() []string {
+	return p.t2.AppendKeys(p.t1.AppendKeys(nil))
+}
+
+func (p *ARCPolicy) Reset() {
+	p.t1.Reset()
+	p.t2.Reset()
+	p.b1.Reset()
+	p.b2.Reset()
+	p.p = 0
+}
+
+// WTinyLFUPolicy implements Window TinyLFU. New keys enter a small LRU
+// window holding 1% of the keys; the main area is a segmented LRU of
+// probation and protected keys. When the cache is full, the oldest window key
+// only displaces the oldest main key if a count-min sketch of recent accesses
+// estimates it to be used more often, so one-off scans can't flush
+// frequently used keys.
+type WTinyLFUPolicy struct {
+	window    *orderedKeys
+	probation *orderedKeys
+	protected *orderedKeys
+	sketch    *countMinSketch
+}
+
+const (
+	wtinylfuWindowPercent    = 1
+	wtinylfuProtectedPercent = 80
+)
+
+func NewWTinyLFUPolicy() *WTinyLFUPolicy {
+	return &WTinyLFUPolicy{
+		window:    newOrderedKeys(),
+		probation: newOrderedKeys(),
+		protected: newOrderedKeys(),
+		sketch:    newCountMinSketch(1 << 16),
+	}
+}
+
+func (p *WTinyLFUPolicy) Add(key string) {
+	p.sketch.Increment(key)
+	p.window.PushFront(key)
+
+	// Until the cache is full, keys leave the window without competing for
+	// admission. Once it is, Evict keeps the window at its share.
+	for p.window.Len() > p.windowTarget() {
+		k, _ := p.window.PopBack()
+		p.probation.PushFront(k)
+	}
+}
+
+func (p *WTinyLFUPolicy) Hit(key string) {
+	p.sketch.Increment(key)
+
+	switch {
+	case p.window.Contains(key):
+		p.window.MoveToFront(key)
+	case p.probation.Remove(key):
+		p.protected.PushFront(key)
+
+		// demote the oldest protected keys once protected is over its share
+		mainLen := p.probation.Len() + p.protected.Len()
+		for p.protected.Len() > max(mainLen*wtinylfuProtectedPercent/100, 1) {
+			k, _ := p.protected.PopBack()
+			p.probation.PushFront(k)
+		}
+	default:
+		p.protected.MoveToFront(key)
+	}
+}
+
+func (p *WTinyLFUPolicy) Remove(key string) {
+	if !p.window.Remove(key) && !p.probation.Remove(key) {
+		p.protected.Remove(key)
+	}
+}
+
+func (p *WTinyLFUPolicy) Evict() (string, bool) {
+	victim, hasVictim := p.probation.Back()
+	if !hasVictim {
+		victim, hasVictim = p.protected.Back()
+	}
+
+	if p.window.Len() > 0 && (!hasVictim || p.window.Len() >= p.windowTarget()) {
+		candidate, _ := p.window.PopBack()
+
+		if !hasVictim {
+			return candidate, true
+		}
+
+		// admission: the window candidate replaces the main victim only if used more often
+		if p.sketch.Estimate(candidate) > p.sketch.Estimate(victim) {
+			p.Remove(victim)
+			p.probation.PushFront(candidate)
+
+			return victim, true
+		}
+
+		return candidate, true
+	}
+
+	if !hasVictim {
+		return "", false
+	}
+
+	p.Remove(victim)
+
+	return victim, true
+}
+
+func (p *WTinyLFUPolicy) windowTarget() int {
+	total := p.window.Len() + p.probation.Len() + p.protected.Len()
+
+	return max(total*wtinylfuWindowPercent/100, 1)
+}
+
+func (p *WTinyLFUPolicy) Keys() []string {
+	return p.protected.AppendKeys(p.window.AppendKeys(p.probation.AppendKeys(nil)))
+}
+
+func (p *WTinyLFUPolicy) Reset() {
+	p.window.Reset()
+	p.probation.Reset()
+	p.protected.Reset()
+	p.sketch.Reset()
+}
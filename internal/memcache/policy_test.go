@@ -0,0 +1,232 @@
+package memcache
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func evictAll(p Policy) []string {
+	var keys []string
+
+	for {
+		k, ok := p.Evict()
+		if !ok {
+			return keys
+		}
+
+		keys = append(keys, k)
+	}
+}
+
+func TestLRUPolicy(t *testing.T) {
+	eval := is.New(t)
+
+	p := NewLRUPolicy()
+	p.Add("a")
+	p.Add("b")
+	p.Add("c")
+	p.Hit("a")
+	p.Remove("c")
+
+	eval.Equal(evictAll(p), []string{"b", "a"})
+}
+
+func TestLFUPolicy(t *testing.T) {
+	eval := is.New(t)
+
+	p := NewLFUPolicy()
+	p.Add("a")
+	p.Add("b")
+	p.Add("c")
+	p.Hit("a")
+	p.Hit("a")
+	p.Hit("c")
+
+	eval.Equal(evictAll(p), []string{"b", "c", "a"})
+
+	// ties are broken by recency
+	p.Add("x")
+	p.Add("y")
+	p.Hit("x")
+	p.Hit("y")
+	p.Remove("z")
+
+	eval.Equal(evictAll(p), []string{"x", "y"})
+}
+
+func TestARCPolicy(t *testing.T) {
+	eval := is.New(t)
+
+	p := NewARCPolicy()
+	p.Add("a")
+	p.Add("b")
+	p.Add("c")
+	p.Hit("a")
+
+	// keys seen once are evicted before keys seen twice
+	k, _ := p.Evict()
+	eval.Equal(k, "b")
+	eval.True(p.b1.Contains("b"))
+
+	// re-adding a key evicted from t1 grows t1's target and keeps it as frequent
+	p.Add("b")
+	eval.Equal(p.p, 1)
+	eval.True(p.t2.Contains("b"))
+
+	eval.Equal(len(evictAll(p)), 3)
+	eval.Equal(p.t1.Len()+p.t2.Len(), 0)
+}
+
+func TestPolicyKeys(t *testing.T) {
+	for _, name := range benchmarkPolicies {
+		t.Run(name, func(t *testing.T) {
+			eval := is.New(t)
+
+			p, err := NewPolicy(name)
+			eval.NoErr(err)
+
+			p.Add("a")
+			p.Add("b")
+			p.Add("c")
+			p.Hit("a")
+			p.Hit("a")
+
+			keys := p.Keys()
+			eval.Equal(len(keys), 3)
+
+			// the order is exact for policies without an admission contest
+			if name != PolicyWTinyLFU {
+				eval.Equal(keys, evictAll(p))
+			}
+		})
+	}
+}
+
+func TestWTinyLFUPolicyScanResistance(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewMemoryCacheWithPolicy(time.Minute, 100*recordSize(), 1000, NewWTinyLFUPolicy())
+
+	for i := range 100 {
+		eval.NoErr(c.Set("hot"+strconv.Itoa(i), &Record{}))
+	}
+
+	for range 5 {
+		for i := range 100 {
+			eval.True(c.Get("hot"+strconv.Itoa(i)) != nil)
+		}
+	}
+
+	// a scan of keys seen once doesn't flush the frequently used ones
+	for i := range 1000 {
+		eval.NoErr(c.Set("scan"+strconv.Itoa(i), &Record{}))
+	}
+
+	hits := 0
+	for i := range 100 {
+		if c.Get("hot"+strconv.Itoa(i)) != nil {
+			hits++
+		}
+	}
+
+	eval.True(hits >= 95)
+}
+
+func TestCountMinSketch(t *testing.T) {
+	eval := is.New(t)
+
+	s := newCountMinSketch(64)
+
+	for range 5 {
+		s.Increment("a")
+	}
+
+	for range 20 {
+		s.Increment("b")
+	}
+
+	eval.True(s.Estimate("a") >= 5)
+	eval.Equal(s.Estimate("b"), uint8(sketchMaxCounter))
+
+	// counters are halved after 10*width additions
+	for i := range 10 * 64 {
+		s.Increment(strconv.Itoa(i))
+	}
+
+	eval.True(s.Estimate("b") <= sketchMaxCounter/2+2)
+}
+
+// recordSize is the size of an empty record with a 3-6 character key.
+func recordSize() int {
+	return entrySize("12345", &Record{})
+}
+
+var benchmarkPolicies = []string{PolicyLRU, PolicyLFU, PolicyARC, PolicyWTinyLFU}
+
+// benchmarkHitRate replays keys against a cache holding capacity records and
+// reports the percentage of hits.
+func benchmarkHitRate(b *testing.B, policy string, capacity int, next func() string) {
+	p, err := NewPolicy(policy)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c := NewMemoryCacheWithPolicy(time.Hour, capacity*recordSize(), 1<<20, p)
+
+	hits := 0
+
+	b.ResetTimer()
+
+	for range b.N {
+		k := next()
+
+		if c.Get(k) != nil {
+			hits++
+
+			continue
+		}
+
+		_ = c.Set(k, &Record{})
+	}
+
+	b.ReportMetric(100*float64(hits)/float64(b.N), "hit%")
+}
+
+// BenchmarkPolicyZipf replays a Zipfian distribution over 100k keys with room for 1% of them.
+func BenchmarkPolicyZipf(b *testing.B) {
+	for _, policy := range benchmarkPolicies {
+		b.Run(policy, func(b *testing.B) {
+			zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 100_000)
+
+			benchmarkHitRate(b, policy, 1000, func() string {
+				return strconv.FormatUint(zipf.Uint64(), 10)
+			})
+		})
+	}
+}
+
+// BenchmarkPolicyScan interleaves a Zipfian hot set with long scans of keys
+// that are never requested again, as a crawler would.
+func BenchmarkPolicyScan(b *testing.B) {
+	for _, policy := range benchmarkPolicies {
+		b.Run(policy, func(b *testing.B) {
+			zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 2_000)
+			i := 0
+
+			benchmarkHitRate(b, policy, 1000, func() string {
+				i++
+
+				// every other 5000 requests are a scan
+				if (i/5000)%2 == 1 {
+					return "scan" + strconv.Itoa(i)
+				}
+
+				return strconv.FormatUint(zipf.Uint64(), 10)
+			})
+		})
+	}
+}
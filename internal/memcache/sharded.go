@@ -0,0 +1,176 @@
+package memcache
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+// ShardedMemoryCache spreads records over independently locked MemoryCache
+// shards so that concurrent requests for different keys don't contend on a
+// single mutex. Each shard runs its own eviction policy over an equal share
+// of the capacity, so the global size limit holds while eviction order is
+// only approximately global.
+type ShardedMemoryCache struct {
+	shards  []*MemoryCache
+	seed    maphash.Seed
+	janitor *janitor
+	monitor *janitor
+}
+
+// NewShardedMemoryCache creates a cache of n shards, each evicting by a
+// policy returned by newPolicy. n is rounded up to a power of two and lowered
+// so that every shard can hold a record of maxRecordSize.
+func NewShardedMemoryCache(ttl time.Duration, maxSize, maxRecordSize, n int, newPolicy func() Policy) *ShardedMemoryCache {
+	shards := 1
+	for shards < n {
+		shards <<= 1
+	}
+
+	for shards > 1 && maxSize/shards < maxRecordSize {
+		shards >>= 1
+	}
+
+	c := &ShardedMemoryCache{
+		shards: make([]*MemoryCache, shards),
+		seed:   maphash.MakeSeed(),
+	}
+
+	for i := range c.shards {
+		c.shards[i] = NewMemoryCacheWithPolicy(ttl, maxSize/shards, maxRecordSize, newPolicy())
+	}
+
+	return c
+}
+
+func (c *ShardedMemoryCache) shard(k string) *MemoryCache {
+	return c.shards[maphash.String(c.seed, k)&uint64(len(c.shards)-1)]
+}
+
+func (c *ShardedMemoryCache) Get(k string) *Record {
+	return c.shard(k).Get(k)
+}
+
+func (c *ShardedMemoryCache) Lookup(k string) (*Record, bool) {
+	return c.shard(k).Lookup(k)
+}
+
+func (c *ShardedMemoryCache) Set(k string, data *Record) error {
+	return c.shard(k).Set(k, data)
+}
+
+func (c *ShardedMemoryCache) SetWithTTL(k string, data *Record, ttl time.Duration) error {
+	return c.shard(k).SetWithTTL(k, data, ttl)
+}
+
+func (c *ShardedMemoryCache) Restore(k string, data *Record) error {
+	return c.shard(k).Restore(k, data)
+}
+
+func (c *ShardedMemoryCache) Delete(k string) bool {
+	return c.shard(k).Delete(k)
+}
+
+func (c *ShardedMemoryCache) Purge() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.Purge()
+	}
+
+	return n
+}
+
+func (c *ShardedMemoryCache) Count() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.Count()
+	}
+
+	return n
+}
+
+// Sweep removes expired records from every shard, examining at most limit
+// records in total, and returns the number of records removed.
+func (c *ShardedMemoryCache) Sweep(limit int) int {
+	perShard := max(limit/len(c.shards), 1)
+
+	n := 0
+	for _, s := range c.shards {
+		n += s.Sweep(perShard)
+	}
+
+	return n
+}
+
+// StartJanitor removes expired records in the background every interval,
+// examining at most limit records per run, until Close is called.
+func (c *ShardedMemoryCache) StartJanitor(interval time.Duration, limit int) {
+	c.janitor = startSweeper(interval, func() int {
+		return c.Sweep(limit)
+	})
+}
+
+// Shrink evicts records from every shard until at least n bytes are freed
+// or the cache is empty, and returns the number of bytes freed.
+func (c *ShardedMemoryCache) Shrink(n int) int {
+	perShard := (n + len(c.shards) - 1) / len(c.shards)
+
+	freed := 0
+	for _, s := range c.shards {
+		freed += s.Shrink(perShard)
+	}
+
+	return freed
+}
+
+// StartMemoryMonitor checks the memory use of the process every interval
+// and shrinks the cache when it nears limit, until Close is called.
+func (c *ShardedMemoryCache) StartMemoryMonitor(interval time.Duration, limit int64) {
+	c.monitor = startMemoryMonitor(interval, limit, c.Shrink)
+}
+
+// Close stops the janitor and the memory monitor, if started.
+func (c *ShardedMemoryCache) Close() error {
+	if c.janitor != nil {
+		c.janitor.Close()
+	}
+
+	if c.monitor != nil {
+		c.monitor.Close()
+	}
+
+	return nil
+}
+
+// Stats returns the sum of the counters of every shard.
+func (c *ShardedMemoryCache) Stats() Stats {
+	var stats Stats
+
+	for _, s := range c.shards {
+		st := s.Stats()
+
+		stats.Hits += st.Hits
+		stats.Misses += st.Misses
+		stats.StaleHits += st.StaleHits
+		stats.Evictions.Capacity += st.Evictions.Capacity
+		stats.Evictions.Expired += st.Evictions.Expired
+		stats.Evictions.Purged += st.Evictions.Purged
+		stats.Evictions.Memory += st.Evictions.Memory
+		stats.RejectedTooLarge += st.RejectedTooLarge
+		stats.Records += st.Records
+		stats.BytesUsed += st.BytesUsed
+		stats.Capacity += st.Capacity
+	}
+
+	return stats
+}
+
+func (c *ShardedMemoryCache) Entries(f EntryFilter) ([]EntryInfo, int) {
+	var entries []EntryInfo
+
+	for _, s := range c.shards {
+		shardEntries, _ := s.Entries(EntryFilter{Prefix: f.Prefix})
+		entries = append(entries, shardEntries...)
+	}
+
+	return PageEntries(entries, f)
+}
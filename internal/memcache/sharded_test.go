@@ -0,0 +1,182 @@
+package memcache
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func newLRU() Policy { return NewLRUPolicy() }
+
+func TestShardedMemoryCache(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewShardedMemoryCache(time.Minute, 64*1024, 512, 5, newLRU)
+	eval.Equal(len(c.shards), 8)
+
+	for i := range 100 {
+		eval.NoErr(c.Set(strconv.Itoa(i), &Record{Body: []byte("body")}))
+	}
+
+	eval.Equal(c.Count(), 100)
+
+	for i := range 100 {
+		eval.True(c.Get(strconv.Itoa(i)) != nil)
+	}
+
+	eval.True(c.Delete("1"))
+
+	entries, total := c.Entries(EntryFilter{Prefix: "1", Limit: 5})
+	eval.Equal(total, 10)
+	eval.Equal(entries[0].Key, "10")
+	eval.Equal(len(entries), 5)
+
+	stats := c.Stats()
+	eval.Equal(stats.Hits, int64(100))
+	eval.Equal(stats.Records, 99)
+	eval.Equal(stats.Capacity, 64*1024)
+	eval.Equal(stats.Evictions.Purged, int64(1))
+
+	eval.Equal(c.Purge(), 99)
+	eval.Equal(c.Stats().BytesUsed, 0)
+}
+
+func TestShardedMemoryCacheCapacity(t *testing.T) {
+	eval := is.New(t)
+
+	// shards are reduced so that each one can hold a record of maxRecordSize
+	c := NewShardedMemoryCache(time.Minute, 4000, 1200, 16, newLRU)
+	eval.Equal(len(c.shards), 2)
+
+	for i := range 100 {
+		eval.NoErr(c.Set(strconv.Itoa(i), &Record{Body: make([]byte, 60)}))
+	}
+
+	stats := c.Stats()
+	eval.True(stats.BytesUsed <= 4000)
+	eval.True(stats.Evictions.Capacity > 0)
+}
+
+func TestShardedMemoryCacheConcurrency(t *testing.T) {
+	c := NewShardedMemoryCache(time.Minute, 64*1024, 1024, 8, newLRU)
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Go(func() {
+			for i := range 1000 {
+				k := strconv.Itoa((w*1000 + i) % 300)
+
+				if c.Get(k) == nil {
+					_ = c.Set(k, &Record{Body: make([]byte, 100)})
+				}
+
+				if i%100 == 0 {
+					c.Delete(k)
+				}
+			}
+		})
+	}
+
+	wg.Wait()
+
+	if used := c.Stats().BytesUsed; used > 64*1024 {
+		t.Fatalf("bytes used %d exceed the capacity", used)
+	}
+}
+
+// TestCacheConcurrentReadWrite hammers Get, Set, Delete and eviction on the
+// same few keys while readers modify the records they get back. Run with -race.
+func TestCacheConcurrentReadWrite(t *testing.T) {
+	caches := map[string]interface {
+		Get(k string) *Record
+		Set(k string, data *Record) error
+		Delete(k string) bool
+	}{
+		"memory":  NewMemoryCache(time.Minute, 2048, 512),
+		"sharded": NewShardedMemoryCache(time.Minute, 4096, 512, 4, newLRU),
+	}
+
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for w := range 8 {
+				wg.Go(func() {
+					for i := range 2000 {
+						k := strconv.Itoa((w + i) % 40)
+
+						switch i % 4 {
+						case 0:
+							r := &Record{
+								Body:    []byte("body-" + k),
+								Headers: http.Header{"Key": []string{k}},
+							}
+							_ = c.Set(k, r)
+
+							// the stored copy must not see this
+							r.Body[0] = 'X'
+							r.Headers.Set("Key", "X")
+						case 1:
+							if i%100 == 1 {
+								c.Delete(k)
+							}
+						default:
+							r := c.Get(k)
+							if r == nil {
+								continue
+							}
+
+							if string(r.Body) != "body-"+k || r.Headers.Get("Key") != k {
+								t.Errorf("record for %s has body %q and header %q", k, r.Body, r.Headers.Get("Key"))
+							}
+
+							r.Body[0] = 'X'
+							r.Headers.Set("Key", "X")
+						}
+					}
+				})
+			}
+
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkMemoryCacheParallel compares a single lock with sharded locks under
+// a read-mostly workload. Run with -cpu 1,2,4,8 to see throughput scale.
+func BenchmarkMemoryCacheParallel(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			c := NewShardedMemoryCache(time.Hour, 64*1024*1024, 1024, shards, newLRU)
+
+			keys := make([]string, 10_000)
+			for i := range keys {
+				keys[i] = "GET:/item/" + strconv.Itoa(i)
+				_ = c.Set(keys[i], &Record{Body: make([]byte, 100)})
+			}
+
+			var worker atomic.Int64
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(worker.Add(1)) * 7919
+
+				for pb.Next() {
+					i++
+					k := keys[i%len(keys)]
+
+					if i%10 == 0 {
+						_ = c.Set(k, &Record{Body: make([]byte, 100)})
+					} else {
+						c.Get(k)
+					}
+				}
+			})
+		})
+	}
+}
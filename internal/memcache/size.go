@@ -0,0 +1,50 @@
+package memcache
+
+import "unsafe"
+
+// Sizes of the runtime structures behind a cached record. Map and policy
+// overheads are estimates; Go doesn't expose the real per-entry cost.
+const (
+	stringHeaderSize = int(unsafe.Sizeof(""))
+	sliceHeaderSize  = int(unsafe.Sizeof([]string(nil)))
+	pointerSize      = int(unsafe.Sizeof(uintptr(0)))
+	recordStructSize = int(unsafe.Sizeof(Record{}))
+
+	// mapHeaderSize approximates the fixed cost of a non-nil map.
+	mapHeaderSize = 48
+
+	// mapEntryOverhead approximates the per-entry cost of a map beyond its
+	// key and value: control bytes and the slack kept below the load factor.
+	mapEntryOverhead = 16
+
+	// policyEntryOverhead approximates the bookkeeping an eviction policy
+	// keeps per key, such as a list element and an index entry.
+	policyEntryOverhead = 96
+)
+
+// Calsize returns the approximate number of bytes r occupies in memory,
+// counting the capacity of the body and the headers' map, slices and strings.
+func (r *Record) Calsize() int {
+	size := recordStructSize + cap(r.Body) + len(r.ContentEncoding)
+
+	if r.Headers != nil {
+		size += mapHeaderSize
+	}
+
+	for k, vals := range r.Headers {
+		size += stringHeaderSize + len(k) + sliceHeaderSize + mapEntryOverhead
+		size += cap(vals) * stringHeaderSize
+
+		for _, v := range vals {
+			size += len(v)
+		}
+	}
+
+	return size
+}
+
+// entrySize returns the number of bytes charged against the cache capacity
+// for storing r under k: the record, the key and the cache's bookkeeping.
+func entrySize(k string, r *Record) int {
+	return r.Calsize() + stringHeaderSize + len(k) + pointerSize + mapEntryOverhead + policyEntryOverhead
+}
@@ -0,0 +1,90 @@
+package memcache
+
+import "hash/fnv"
+
+const (
+	sketchDepth      = 4
+	sketchMaxCounter = 15
+)
+
+// countMinSketch estimates how often keys were seen using a fixed amount of
+// memory. Counters saturate at 15 and are halved periodically so that the
+// estimates favour recent accesses.
+type countMinSketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// newCountMinSketch creates a sketch with width counters per row, rounded up to a power of two.
+func newCountMinSketch(width int) *countMinSketch {
+	w := 1
+	for w < width {
+		w <<= 1
+	}
+
+	s := &countMinSketch{
+		mask:    uint64(w - 1),
+		resetAt: 10 * w,
+	}
+
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+
+	return s
+}
+
+func (s *countMinSketch) Increment(key string) {
+	h1, h2 := sketchHashes(key)
+
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < sketchMaxCounter {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.age()
+	}
+}
+
+func (s *countMinSketch) Estimate(key string) uint8 {
+	h1, h2 := sketchHashes(key)
+
+	est := uint8(sketchMaxCounter)
+	for i := range s.rows {
+		est = min(est, s.rows[i][(h1+uint64(i)*h2)&s.mask])
+	}
+
+	return est
+}
+
+func (s *countMinSketch) Reset() {
+	for i := range s.rows {
+		clear(s.rows[i])
+	}
+
+	s.additions = 0
+}
+
+func (s *countMinSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+
+	s.additions /= 2
+}
+
+func sketchHashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+
+	return sum, sum>>32 | 1
+}
@@ -0,0 +1,187 @@
+package memcache
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"slices"
+	"time"
+)
+
+// A snapshot starts with snapshotMagic and a version byte, followed by the
+// number of entries and each entry's key and encoded record. Every entry
+// ends with a CRC-32C of its key and record, and the snapshot ends with a
+// CRC-32C of everything before it, so that truncated or corrupted snapshots
+// are detected.
+const (
+	snapshotMagic   = "RPCACHE"
+	snapshotVersion = 1
+)
+
+var (
+	ErrInvalidSnapshot = errors.New("invalid cache snapshot")
+
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// SnapshotEntry is a record saved in a snapshot along with its key.
+type SnapshotEntry struct {
+	Key    string
+	Record *Record
+}
+
+// Snapshot returns copies of the fresh records, ordered from the first to the
+// last to be evicted so that restoring them in order recreates the eviction
+// order.
+func (cache *MemoryCache) Snapshot() []SnapshotEntry {
+	cache.mu.RLock()
+
+	now := time.Now()
+	keys := cache.policy.Keys()
+
+	entries := make([]SnapshotEntry, 0, len(keys))
+	for _, k := range keys {
+		if r, ok := cache.records[k]; ok && !r.Expired(now) {
+			entries = append(entries, SnapshotEntry{Key: k, Record: r})
+		}
+	}
+
+	cache.mu.RUnlock()
+
+	// Stored records aren't modified, so they can be copied without the lock.
+	for i := range entries {
+		entries[i].Record = entries[i].Record.Clone()
+	}
+
+	return entries
+}
+
+// Snapshot returns the snapshots of every shard, interleaved by their
+// position in their shard's eviction order. Keys may be spread over the
+// shards differently when restored, so this approximates a global order.
+func (c *ShardedMemoryCache) Snapshot() []SnapshotEntry {
+	type rankedEntry struct {
+		SnapshotEntry
+		rank float64
+	}
+
+	var ranked []rankedEntry
+
+	for _, s := range c.shards {
+		entries := s.Snapshot()
+		for i, e := range entries {
+			ranked = append(ranked, rankedEntry{e, float64(i+1) / float64(len(entries))})
+		}
+	}
+
+	slices.SortStableFunc(ranked, func(a, b rankedEntry) int {
+		return cmp.Compare(a.rank, b.rank)
+	})
+
+	entries := make([]SnapshotEntry, len(ranked))
+	for i, e := range ranked {
+		entries[i] = e.SnapshotEntry
+	}
+
+	return entries
+}
+
+// WriteSnapshot writes entries to w.
+func WriteSnapshot(w io.Writer, entries []SnapshotEntry) error {
+	sum := crc32.New(crc32c)
+	bw := bufio.NewWriter(io.MultiWriter(w, sum))
+
+	b := append([]byte(snapshotMagic), snapshotVersion)
+	b = binary.AppendUvarint(b, uint64(len(entries)))
+
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		record, err := e.Record.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		b = appendBytes(b[:0], []byte(e.Key))
+		b = appendBytes(b, record)
+		b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, crc32c))
+
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, sum.Sum32()))
+
+	return err
+}
+
+// ReadSnapshot reads the entries written by WriteSnapshot. It returns
+// ErrInvalidSnapshot, and no entries, if the snapshot is corrupted.
+func ReadSnapshot(r io.Reader) ([]SnapshotEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(snapshotMagic)+1+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crc32c) != binary.BigEndian.Uint32(trailer) {
+		return nil, ErrInvalidSnapshot
+	}
+
+	d := decoder{buf: body[len(snapshotMagic):]}
+
+	if d.byte() != snapshotVersion {
+		return nil, ErrInvalidSnapshot
+	}
+
+	n := d.uvarint()
+	if n > uint64(len(data)) {
+		return nil, ErrInvalidSnapshot
+	}
+
+	entries := make([]SnapshotEntry, 0, n)
+	for range n {
+		start := d.buf
+
+		key := d.bytes()
+		record := d.bytes()
+
+		if d.err != nil || len(d.buf) < 4 {
+			return nil, ErrInvalidSnapshot
+		}
+
+		entryLen := len(start) - len(d.buf)
+		if crc32.Checksum(start[:entryLen], crc32c) != binary.BigEndian.Uint32(d.buf) {
+			return nil, ErrInvalidSnapshot
+		}
+
+		d.buf = d.buf[4:]
+
+		var rec Record
+		if err := rec.UnmarshalBinary(record); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		entries = append(entries, SnapshotEntry{Key: string(key), Record: &rec})
+	}
+
+	if d.err != nil || len(d.buf) != 0 {
+		return nil, ErrInvalidSnapshot
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,113 @@
+package memcache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestSnapshot(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewMemoryCache(time.Minute, 10000, 1000)
+
+	for _, k := range []string{"a", "b", "c"} {
+		eval.NoErr(c.Set(k, &Record{StatusCode: http.StatusOK, Body: []byte("body " + k), Headers: http.Header{"Key": {k}}}))
+	}
+
+	eval.NoErr(c.SetWithTTL("expired", &Record{}, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	c.Get("a")
+
+	entries := c.Snapshot()
+	eval.Equal(len(entries), 3)
+
+	var buf bytes.Buffer
+	eval.NoErr(WriteSnapshot(&buf, entries))
+
+	got, err := ReadSnapshot(&buf)
+	eval.NoErr(err)
+	eval.Equal(len(got), 3)
+
+	// ordered from the first to the last to be evicted
+	for i, k := range []string{"b", "c", "a"} {
+		eval.Equal(got[i].Key, k)
+		eval.Equal(string(got[i].Record.Body), "body "+k)
+		eval.Equal(got[i].Record.Headers.Get("Key"), k)
+		eval.True(got[i].Record.ExpiresAt().Equal(entries[i].Record.ExpiresAt()))
+	}
+
+	// restoring recreates the eviction order
+	restored := NewMemoryCache(time.Minute, 10000, 1000)
+	for _, e := range got {
+		eval.NoErr(restored.Restore(e.Key, e.Record))
+	}
+
+	eval.Equal(restored.Shrink(1), entrySize("b", got[0].Record))
+	eval.True(restored.Get("b") == nil)
+	eval.True(restored.Get("c") != nil)
+}
+
+func TestShardedSnapshot(t *testing.T) {
+	eval := is.New(t)
+
+	c := NewShardedMemoryCache(time.Minute, 64*1024, 1024, 4, newLRU)
+
+	for i := range 40 {
+		eval.NoErr(c.Set(strconv.Itoa(i), &Record{Body: []byte(strconv.Itoa(i))}))
+	}
+
+	entries := c.Snapshot()
+	eval.Equal(len(entries), 40)
+
+	// the most recently used keys of each shard come last
+	last := map[string]bool{}
+	for _, e := range entries[len(entries)-4:] {
+		last[e.Key] = true
+	}
+
+	for _, s := range c.shards {
+		keys := s.policy.Keys()
+		eval.True(last[keys[len(keys)-1]])
+	}
+}
+
+func TestReadSnapshotCorrupted(t *testing.T) {
+	eval := is.New(t)
+
+	var buf bytes.Buffer
+	eval.NoErr(WriteSnapshot(&buf, []SnapshotEntry{
+		{Key: "a", Record: &Record{Body: []byte("body a")}},
+		{Key: "b", Record: &Record{Body: []byte("body b")}},
+	}))
+
+	data := buf.Bytes()
+
+	_, err := ReadSnapshot(bytes.NewReader(data))
+	eval.NoErr(err)
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0xff
+
+	wrongVersion := bytes.Clone(data)
+	wrongVersion[len(snapshotMagic)] = snapshotVersion + 1
+
+	for name, data := range map[string][]byte{
+		"empty":         nil,
+		"truncated":     data[:len(data)-1],
+		"flipped byte":  flipped,
+		"wrong version": wrongVersion,
+		"not snapshot":  []byte("not a snapshot at all"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			entries, err := ReadSnapshot(bytes.NewReader(data))
+			eval.Equal(err, ErrInvalidSnapshot)
+			eval.Equal(len(entries), 0)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package reverseproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// heuristicallyCacheable are the status codes RFC 9110 section 15.1 defines
+// as heuristically cacheable, which may be stored without explicit freshness
+// information from the upstream.
+var heuristicallyCacheable = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+	http.StatusPartialContent,
+	http.StatusMultipleChoices,
+	http.StatusMovedPermanently,
+	http.StatusPermanentRedirect,
+	http.StatusNotFound,
+	http.StatusMethodNotAllowed,
+	http.StatusGone,
+	http.StatusRequestURITooLong,
+	http.StatusNotImplemented,
+}
+
+// cacheableStatuses decides which responses are stored by status code, and
+// for how long. Negative responses, client and server errors, are stored for
+// negativeTTL.
+type cacheableStatuses struct {
+	codes       map[int]bool
+	negativeTTL time.Duration
+}
+
+// newCacheableStatuses returns the heuristically cacheable status codes and
+// extra. A negative negativeTTL stores no negative response.
+func newCacheableStatuses(extra []int, negativeTTL time.Duration) (*cacheableStatuses, error) {
+	s := &cacheableStatuses{
+		codes:       make(map[int]bool),
+		negativeTTL: negativeTTL,
+	}
+
+	for _, code := range heuristicallyCacheable {
+		s.codes[code] = true
+	}
+
+	for _, code := range extra {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid cacheable status code %d", code)
+		}
+
+		s.codes[code] = true
+	}
+
+	return s, nil
+}
+
+func (s *cacheableStatuses) cacheable(code int) bool {
+	if isNegative(code) && s.negativeTTL < 0 {
+		return false
+	}
+
+	return s.codes[code]
+}
+
+// ttl returns how long a response with code is stored, ttl being the TTL of
+// the other responses.
+func (s *cacheableStatuses) ttl(code int, ttl time.Duration) time.Duration {
+	if isNegative(code) && s.negativeTTL > 0 {
+		return s.negativeTTL
+	}
+
+	return ttl
+}
+
+func isNegative(code int) bool {
+	return code >= http.StatusBadRequest
+}
+
+// partialKey returns the key a partial response to r is stored under, or an
+// empty string if it isn't stored. Responses to conditional range requests
+// are not, as they may be the full body.
+func partialKey(key string, r *http.Request) string {
+	rangeHeader := r.Header.Get("Range")
+	if r.Method != http.MethodGet || rangeHeader == "" || r.Header.Get("If-Range") != "" {
+		return ""
+	}
+
+	return variantKey(key, "range", rangeHeader)
+}
+
+// hasDirective reports whether the Cache-Control header of h holds
+// directive, with or without an argument.
+func hasDirective(h http.Header, directive string) bool {
+	for _, vals := range h.Values("Cache-Control") {
+		for v := range strings.SplitSeq(vals, ",") {
+			name, _, _ := strings.Cut(v, "=")
+			if strings.EqualFold(strings.TrimSpace(name), directive) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestCacheableStatuses(t *testing.T) {
+	tests := []struct {
+		name        string
+		extra       []int
+		negativeTTL time.Duration
+		code        int
+		cacheable   bool
+		ttl         time.Duration
+	}{
+		{name: "ok", negativeTTL: time.Second, code: http.StatusOK, cacheable: true, ttl: time.Minute},
+		{name: "moved", negativeTTL: time.Second, code: http.StatusMovedPermanently, cacheable: true, ttl: time.Minute},
+		{name: "not found", negativeTTL: time.Second, code: http.StatusNotFound, cacheable: true, ttl: time.Second},
+		{name: "gone", negativeTTL: time.Second, code: http.StatusGone, cacheable: true, ttl: time.Second},
+		{name: "found", negativeTTL: time.Second, code: http.StatusFound, cacheable: false},
+		{name: "extra found", extra: []int{302}, negativeTTL: time.Second, code: http.StatusFound, cacheable: true, ttl: time.Minute},
+		{name: "extra server error", extra: []int{503}, negativeTTL: time.Second, code: http.StatusServiceUnavailable, cacheable: true, ttl: time.Second},
+		{name: "negative disabled", negativeTTL: -1, code: http.StatusNotFound, cacheable: false},
+		{name: "negative disabled ok", negativeTTL: -1, code: http.StatusOK, cacheable: true, ttl: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			s, err := newCacheableStatuses(tt.extra, tt.negativeTTL)
+			eval.NoErr(err)
+
+			eval.Equal(s.cacheable(tt.code), tt.cacheable)
+
+			if tt.cacheable {
+				eval.Equal(s.ttl(tt.code, time.Minute), tt.ttl)
+			}
+		})
+	}
+
+	_, err := newCacheableStatuses([]int{99}, time.Second)
+	is.New(t).True(err != nil)
+}
+
+func TestNegativeCache(t *testing.T) {
+	eval := is.New(t)
+
+	var upstreamCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+
+		code, _ := strconv.Atoi(r.URL.Query().Get("code"))
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			NegativeTTL:   50 * time.Millisecond,
+			StatusCodes:   []int{http.StatusTooManyRequests},
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	eval.NoErr(err)
+
+	get := func(target string) int {
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		return rec.Code
+	}
+
+	for _, code := range []int{http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError} {
+		target := "/?code=" + strconv.Itoa(code)
+
+		eval.Equal(get(target), code)
+		eval.Equal(get(target), code)
+	}
+
+	// the 500 isn't cached
+	eval.Equal(upstreamCalls.Load(), int32(4))
+
+	rec, err := rproxy.Cache.Get(context.Background(), "GET:/?code=404")
+	eval.NoErr(err)
+	eval.True(rec.TTL(time.Now()) <= 50*time.Millisecond)
+
+	time.Sleep(60 * time.Millisecond)
+
+	eval.Equal(get("/?code=404"), http.StatusNotFound)
+	eval.Equal(upstreamCalls.Load(), int32(5))
+}
+
+func TestPartialContentCache(t *testing.T) {
+	eval := is.New(t)
+
+	const text = "0123456789"
+
+	var upstreamCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(text))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	eval.NoErr(err)
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/file", nil)
+		for name, vals := range header {
+			req.Header[name] = vals
+		}
+
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for range 2 {
+		rec := get(http.Header{"Range": {"bytes=1-3"}})
+		eval.Equal(rec.Code, http.StatusPartialContent)
+		eval.Equal(rec.Header().Get("Content-Range"), "bytes 1-3/10")
+		eval.Equal(rec.Body.String(), "123")
+	}
+
+	eval.Equal(upstreamCalls.Load(), int32(1))
+
+	// the full response isn't taken from a partial one
+	_, err = rproxy.Cache.Get(context.Background(), "GET:/file")
+	eval.Equal(err, cache.ErrNotFound)
+
+	// another range, or a conditional one, isn't answered by it
+	rec := get(http.Header{"Range": {"bytes=4-5"}})
+	eval.Equal(rec.Body.String(), "45")
+
+	rec = get(http.Header{"Range": {"bytes=1-3"}, "If-Range": {"Mon, 02 Jan 2006 15:04:05 GMT"}})
+	eval.Equal(rec.Code, http.StatusOK)
+
+	eval.Equal(upstreamCalls.Load(), int32(3))
+
+	// the full response it got instead is stored
+	rec = get(nil)
+	eval.Equal(rec.Body.String(), text)
+	eval.Equal(upstreamCalls.Load(), int32(3))
+}
@@ -0,0 +1,65 @@
+package reverseproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheStatus describes how the cache handled a request. It is rendered as an
+// RFC 9211 Cache-Status header and the optional legacy X-Cache header.
+type cacheStatus struct {
+	hit    bool
+	fwd    string // miss, stale or bypass
+	stored bool
+	detail string
+	ttl    time.Duration
+}
+
+func (s cacheStatus) String(name string) string {
+	var b strings.Builder
+
+	b.WriteString(name)
+
+	if s.hit {
+		fmt.Fprintf(&b, "; hit; ttl=%d", int(s.ttl.Seconds()))
+
+		return b.String()
+	}
+
+	b.WriteString("; fwd=" + s.fwd)
+
+	if s.stored {
+		b.WriteString("; stored")
+	}
+
+	if s.detail != "" {
+		b.WriteString("; detail=" + s.detail)
+	}
+
+	return b.String()
+}
+
+func (p *ReverseProxy) writeCacheHeaders(h http.Header, status cacheStatus) {
+	if p.cacheStatusHeader {
+		h.Add("Cache-Status", status.String(p.cacheStatusName))
+	}
+
+	if p.xCacheHeader {
+		if status.hit {
+			h.Set("X-Cache", "HIT")
+		} else {
+			h.Set("X-Cache", "MISS")
+		}
+	}
+}
+
+// setAgeHeader adds the time the response spent in the cache to the Age
+// received from the upstream, if any.
+func setAgeHeader(h http.Header, age time.Duration) {
+	upstreamAge, _ := strconv.Atoi(h.Get("Age"))
+
+	h.Set("Age", strconv.Itoa(max(upstreamAge, 0)+int(age.Seconds())))
+}
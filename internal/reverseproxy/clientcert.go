@@ -0,0 +1,129 @@
+package reverseproxy
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/komaldsukhani/reverseproxyexample/internal/routes"
+)
+
+// clientCerts enforces the client certificate modes of routes and forwards
+// the identity of verified client certificates to the upstream.
+type clientCerts struct {
+	// mode applies to routes without one.
+	mode string
+
+	subjectHeader     string
+	sansHeader        string
+	fingerprintHeader string
+}
+
+// newClientCerts returns nil unless the listener verifies client
+// certificates.
+func newClientCerts(cfg *config.TLSConfig) (*clientCerts, error) {
+	if cfg.ClientCAFile == "" {
+		return nil, nil
+	}
+
+	if !routes.ValidClientCert(cfg.ClientAuth) {
+		return nil, fmt.Errorf("invalid client auth %q, want require, optional or none", cfg.ClientAuth)
+	}
+
+	return &clientCerts{
+		mode:              cfg.ClientAuth,
+		subjectHeader:     cfg.ClientSubjectHeader,
+		sansHeader:        cfg.ClientSANsHeader,
+		fingerprintHeader: cfg.ClientFingerprintHeader,
+	}, nil
+}
+
+// forward sets the identity headers of r from its verified client
+// certificate, removing those the client sent. It reports false if route
+// requires a certificate r lacks.
+func (cc *clientCerts) forward(route *routes.Route, r *http.Request) bool {
+	if cc == nil {
+		return true
+	}
+
+	// Clients can't be trusted to send these.
+	for _, name := range cc.headers() {
+		r.Header.Del(name)
+	}
+
+	mode := cmp.Or(route.ClientCert, cc.mode)
+
+	cert := verifiedClientCert(r)
+	if cert == nil {
+		return mode != routes.ClientCertRequire
+	}
+
+	if mode == routes.ClientCertNone {
+		return true
+	}
+
+	r.Header.Set(cc.subjectHeader, cert.Subject.String())
+	r.Header.Set(cc.fingerprintHeader, certFingerprint(cert))
+
+	if sans := subjectAltNames(cert); sans != "" {
+		r.Header.Set(cc.sansHeader, sans)
+	}
+
+	return true
+}
+
+// headers returns the names of the identity headers.
+func (cc *clientCerts) headers() []string {
+	if cc == nil {
+		return nil
+	}
+
+	return []string{cc.subjectHeader, cc.sansHeader, cc.fingerprintHeader}
+}
+
+// verifiedClientCert returns the verified client certificate of r, or nil if
+// it has none. Certificates are only verified by the TLS listener, which
+// fails the handshake if one doesn't verify or is revoked.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certFingerprint returns the hex encoded SHA-256 digest of cert.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// subjectAltNames lists the SANs of cert, each prefixed with its type as in
+// DNS:example.com.
+func subjectAltNames(cert *x509.Certificate) string {
+	var sans []string
+
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+
+	return strings.Join(sans, ", ")
+}
@@ -0,0 +1,248 @@
+package reverseproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/komaldsukhani/reverseproxyexample/internal/routes"
+	"github.com/matryer/is"
+)
+
+func newClientCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://example.com/billing")
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames:       []string{"billing.internal"},
+		IPAddresses:    []net.IP{net.IPv4(10, 0, 0, 1)},
+		EmailAddresses: []string{"billing@example.com"},
+		URIs:           []*url.URL{spiffe},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestClientCerts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte(r.Header.Get("X-Client-Subject") + "|" + r.Header.Get("X-Client-SANs") + "|" + r.Header.Get("X-Client-Fingerprint")))
+	}))
+	defer srv.Close()
+
+	routesFile := filepath.Join(t.TempDir(), "routes.json")
+	err := os.WriteFile(routesFile, []byte(`{"routes": [
+		{"path": "/internal", "clientCert": "require"},
+		{"path": "/public", "clientCert": "none"}
+	]}`), 0o600)
+	is.New(t).NoErr(err)
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL:  srv.URL,
+			RoutesFile: routesFile,
+			TLS: config.TLSConfig{
+				ClientCAFile:            "ca.pem",
+				ClientAuth:              "optional",
+				ClientSubjectHeader:     "X-Client-Subject",
+				ClientSANsHeader:        "X-Client-SANs",
+				ClientFingerprintHeader: "X-Client-Fingerprint",
+			},
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	is.New(t).NoErr(err)
+
+	cert := newClientCertificate(t)
+	sum := sha256.Sum256(cert.Raw)
+	identity := "CN=billing,O=Example|DNS:billing.internal, IP:10.0.0.1, email:billing@example.com, URI:spiffe://example.com/billing|" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name       string
+		path       string
+		cert       bool
+		spoof      bool
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "optional with certificate", path: "/", cert: true, wantStatus: http.StatusOK, wantBody: identity},
+		{name: "optional without certificate", path: "/", wantStatus: http.StatusOK, wantBody: "||"},
+		{name: "spoofed headers", path: "/", spoof: true, wantStatus: http.StatusOK, wantBody: "||"},
+		{name: "spoofed headers with certificate", path: "/", cert: true, spoof: true, wantStatus: http.StatusOK, wantBody: identity},
+		{name: "required with certificate", path: "/internal", cert: true, wantStatus: http.StatusOK, wantBody: identity},
+		{name: "required without certificate", path: "/internal", wantStatus: http.StatusForbidden},
+		{name: "required below", path: "/internal/users", wantStatus: http.StatusForbidden},
+		{name: "required with repeated slashes", path: "//internal/users", wantStatus: http.StatusForbidden},
+		{name: "required with dot segments", path: "/public/../internal", wantStatus: http.StatusForbidden},
+		{name: "required with encoded dot segments", path: "/public/%2E%2E/internal", wantStatus: http.StatusForbidden},
+		{name: "required in another case", path: "/Internal", wantStatus: http.StatusForbidden},
+		{name: "not a segment of required", path: "/internalx", wantStatus: http.StatusOK, wantBody: "||"},
+		{name: "none with certificate", path: "/public", cert: true, spoof: true, wantStatus: http.StatusOK, wantBody: "||"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path+"?case="+strconv.Itoa(i), nil)
+			if tt.cert {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+
+			if tt.spoof {
+				req.Header.Set("X-Client-Subject", "CN=admin")
+				req.Header.Set("X-Client-Fingerprint", "00")
+			}
+
+			rec := httptest.NewRecorder()
+			rproxy.ServeHTTP(rec, req)
+
+			eval.Equal(rec.Code, tt.wantStatus)
+
+			if tt.wantStatus == http.StatusOK {
+				eval.Equal(rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestClientCertsDisabled(t *testing.T) {
+	eval := is.New(t)
+
+	cc, err := newClientCerts(&config.TLSConfig{ClientAuth: "require"})
+	eval.NoErr(err)
+	eval.Equal(cc, nil)
+
+	// Without client certificate verification, identity headers are left
+	// to whatever is in front of the proxy.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Client-Subject", "CN=admin")
+	eval.True(cc.forward(&routes.Route{Path: "/"}, req))
+	eval.Equal(req.Header.Get("X-Client-Subject"), "CN=admin")
+
+	_, err = newClientCerts(&config.TLSConfig{ClientCAFile: "ca.pem", ClientAuth: "sometimes"})
+	eval.True(err != nil)
+}
+
+func TestClientCertsCache(t *testing.T) {
+	eval := is.New(t)
+
+	var calls atomic.Int32
+	var lastHeader atomic.Pointer[http.Header]
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		lastHeader.Store(&r.Header)
+
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+
+		_, _ = w.Write([]byte(r.Header.Get("X-Client-Subject") + "|" + r.Header.Get("X-Client-Fingerprint")))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+			TLS: config.TLSConfig{
+				ClientCAFile:            "ca.pem",
+				ClientAuth:              "optional",
+				ClientSubjectHeader:     "X-Client-Subject",
+				ClientSANsHeader:        "X-Client-SANs",
+				ClientFingerprintHeader: "X-Client-Fingerprint",
+			},
+		},
+		Cache: config.CacheConfig{
+			TTL:                time.Minute,
+			MaxSize:            1 * 1024 * 1024,
+			MaxRecordSize:      1024,
+			RefreshAhead:       1,
+			RefreshMinHits:     1,
+			RefreshConcurrency: 1,
+		},
+	})
+	eval.NoErr(err)
+
+	cert := newClientCertificate(t)
+	identity := "CN=billing,O=Example|" + certFingerprint(cert)
+
+	get := func(path string, withCert bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if withCert {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// responses to a certificate's identity aren't shared unless public
+	rec := get("/me", true)
+	eval.Equal(rec.Body.String(), identity)
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "detail=client-cert"))
+
+	eval.Equal(get("/me", false).Body.String(), "|")
+	eval.Equal(calls.Load(), int32(2))
+
+	// a shared record is refreshed without the identity
+	eval.Equal(get("/public", true).Body.String(), identity)
+	get("/public", true)
+	rproxy.refresher.wait()
+	eval.Equal(calls.Load(), int32(4))
+
+	h := *lastHeader.Load()
+	eval.Equal(h.Get("X-Client-Subject"), "")
+	eval.Equal(h.Get("X-Client-SANs"), "")
+	eval.Equal(h.Get("X-Client-Fingerprint"), "")
+
+	eval.Equal(get("/public", false).Body.String(), "|")
+
+	eval.NoErr(rproxy.Close())
+}
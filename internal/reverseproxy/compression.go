@@ -0,0 +1,256 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
+)
+
+// codec compresses and decompresses whole bodies in one content encoding.
+type codec struct {
+	encode func(body []byte) ([]byte, error)
+	decode func(body []byte) ([]byte, error)
+}
+
+var codecs = map[string]codec{
+	"gzip": {
+		encode: func(body []byte) ([]byte, error) {
+			var buf bytes.Buffer
+
+			return streamEncode(&buf, gzip.NewWriter(&buf), body)
+		},
+		decode: func(body []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+
+			return io.ReadAll(r)
+		},
+	},
+	"br": {
+		encode: func(body []byte) ([]byte, error) {
+			var buf bytes.Buffer
+
+			return streamEncode(&buf, brotli.NewWriter(&buf), body)
+		},
+		decode: func(body []byte) ([]byte, error) {
+			return io.ReadAll(brotli.NewReader(bytes.NewReader(body)))
+		},
+	},
+	"zstd": {
+		encode: func(body []byte) ([]byte, error) {
+			z, err := newZstd()
+			if err != nil {
+				return nil, err
+			}
+
+			return z.enc.EncodeAll(body, nil), nil
+		},
+		decode: func(body []byte) ([]byte, error) {
+			z, err := newZstd()
+			if err != nil {
+				return nil, err
+			}
+
+			return z.dec.DecodeAll(body, nil)
+		},
+	},
+}
+
+type zstdCodec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+// newZstd returns an encoder and decoder shared by all requests, as they are
+// costly to create and EncodeAll and DecodeAll are safe for concurrent use.
+var newZstd = sync.OnceValues(func() (*zstdCodec, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &zstdCodec{enc: enc, dec: dec}, nil
+})
+
+func streamEncode(buf *bytes.Buffer, w io.WriteCloser, body []byte) ([]byte, error) {
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compressor stores eligible response bodies compressed.
+type compressor struct {
+	encoding string
+	minSize  int
+	types    []string
+}
+
+func newCompressor(encoding string, minSize int, types []string) (*compressor, error) {
+	if encoding == "" {
+		return nil, nil
+	}
+
+	if _, ok := codecs[encoding]; !ok {
+		return nil, fmt.Errorf("unsupported cache compression %q, want gzip, br or zstd", encoding)
+	}
+
+	return &compressor{encoding: encoding, minSize: minSize, types: types}, nil
+}
+
+// compress replaces the body of rec with its compressed form if the response
+// is eligible and compressing makes it smaller. Partial responses are left
+// as received, as their ranges refer to the body as sent.
+func (c *compressor) compress(rec *cache.Record) error {
+	if c == nil || rec.StatusCode == http.StatusPartialContent || len(rec.Body) < c.minSize || rec.Headers.Get("Content-Encoding") != "" || !c.eligible(rec.Headers.Get("Content-Type")) {
+		return nil
+	}
+
+	body, err := codecs[c.encoding].encode(rec.Body)
+	if err != nil {
+		return err
+	}
+
+	if len(body) >= len(rec.Body) {
+		return nil
+	}
+
+	rec.Body = body
+	rec.ContentEncoding = c.encoding
+
+	return nil
+}
+
+func (c *compressor) eligible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range c.types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cachedBody returns the body of rec to send in reply to r, along with the
+// content encoding it is sent with. A compressed body is sent as stored if
+// the client accepts its encoding and decompressed otherwise. It is also
+// decompressed for a Range request, whose ranges and If-Range validator
+// refer to the body the upstream sent.
+func cachedBody(r *http.Request, rec *cache.Record) ([]byte, string, error) {
+	if rec.ContentEncoding == "" {
+		return rec.Body, "", nil
+	}
+
+	if r.Header.Get("Range") == "" && acceptsEncoding(r.Header, rec.ContentEncoding) {
+		return rec.Body, rec.ContentEncoding, nil
+	}
+
+	c, ok := codecs[rec.ContentEncoding]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported content encoding %q of cached record", rec.ContentEncoding)
+	}
+
+	body, err := c.decode(rec.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decompress cached record: %w", err)
+	}
+
+	return body, "", nil
+}
+
+// setEncodingHeaders adjusts the headers of a response to a request whose
+// cached record was compressed, when its body is sent with encoding.
+func setEncodingHeaders(h http.Header, encoding string, size int) {
+	if !headerHasToken(h, "Vary", "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	if encoding == "" {
+		return
+	}
+
+	h.Set("Content-Encoding", encoding)
+	h.Set("Content-Length", strconv.Itoa(size))
+
+	// The encoded body differs from the one the upstream validated.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of a request
+// allows a response in encoding.
+func acceptsEncoding(h http.Header, encoding string) bool {
+	accepted := false
+
+	for _, vals := range h.Values("Accept-Encoding") {
+		for v := range strings.SplitSeq(vals, ",") {
+			name, params, _ := strings.Cut(v, ";")
+
+			name = strings.TrimSpace(name)
+			if !strings.EqualFold(name, encoding) && name != "*" {
+				continue
+			}
+
+			q := 1.0
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(qv, 64); err == nil {
+					q = f
+				}
+			}
+
+			// An explicit entry for encoding takes precedence over "*".
+			if name != "*" {
+				return q > 0
+			}
+
+			accepted = q > 0
+		}
+	}
+
+	return accepted
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, vals := range h.Values(name) {
+		for v := range strings.SplitSeq(vals, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,156 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestCompressedCache(t *testing.T) {
+	text := strings.Repeat("a highly compressible line of text\n", 100)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image" {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(text))
+	}))
+	defer srv.Close()
+
+	for _, encoding := range []string{"gzip", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			eval := is.New(t)
+
+			rproxy, err := New(&config.Config{
+				Proxy: config.ProxyConfig{
+					TargetURL: srv.URL,
+				},
+				Cache: config.CacheConfig{
+					TTL:     5 * time.Minute,
+					MaxSize: 1 * 1024 * 1024,
+					// only fits once compressed
+					MaxRecordSize:    1024,
+					Compression:      encoding,
+					CompressionTypes: config.DefaultCacheCompressionTypes(),
+				},
+			})
+			eval.NoErr(err)
+
+			get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if acceptEncoding != "" {
+					req.Header.Set("Accept-Encoding", acceptEncoding)
+				}
+
+				rec := httptest.NewRecorder()
+				rproxy.ServeHTTP(rec, req)
+
+				return rec
+			}
+
+			rec := get("/", "")
+			eval.Equal(rec.Body.String(), text)
+			eval.Equal(rec.Header().Get("Vary"), "Accept-Encoding")
+			eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "stored"))
+
+			stored, err := rproxy.Cache.Get(context.Background(), "GET:/")
+			eval.NoErr(err)
+			eval.Equal(stored.ContentEncoding, encoding)
+
+			// served as stored to clients accepting the encoding
+			rec = get("/", "identity;q=0.5, "+encoding)
+			eval.Equal(rec.Header().Get("Content-Encoding"), encoding)
+			eval.Equal(rec.Header().Get("ETag"), `W/"v1"`)
+			eval.Equal(rec.Body.Bytes(), stored.Body)
+
+			body, err := codecs[encoding].decode(rec.Body.Bytes())
+			eval.NoErr(err)
+			eval.Equal(string(body), text)
+
+			// decompressed for the others
+			rec = get("/", "deflate")
+			eval.Equal(rec.Header().Get("Content-Encoding"), "")
+			eval.Equal(rec.Header().Get("ETag"), `"v1"`)
+			eval.Equal(rec.Header().Get("Vary"), "Accept-Encoding")
+			eval.True(strings.Contains(rec.Header().Get("Cache-Status"), "hit"))
+			eval.Equal(rec.Body.String(), text)
+
+			// ranges are taken from the decompressed body
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			req.Header.Set("Range", "bytes=2-7")
+			req.Header.Set("If-Range", `"v1"`)
+			rec = httptest.NewRecorder()
+			rproxy.ServeHTTP(rec, req)
+			eval.Equal(rec.Code, http.StatusPartialContent)
+			eval.Equal(rec.Header().Get("Content-Encoding"), "")
+			eval.Equal(rec.Header().Get("ETag"), `"v1"`)
+			eval.Equal(rec.Header().Get("Content-Range"), "bytes 2-7/"+strconv.Itoa(len(text)))
+			eval.Equal(rec.Body.String(), text[2:8])
+
+			// only eligible media types are compressed
+			rec = get("/image", "")
+			eval.True(strings.Contains(rec.Header().Get("Cache-Status"), "detail=too-large"))
+		})
+	}
+}
+
+func TestNewCompressor(t *testing.T) {
+	eval := is.New(t)
+
+	c, err := newCompressor("", 0, nil)
+	eval.NoErr(err)
+	eval.True(c == nil)
+
+	_, err = newCompressor("deflate", 0, nil)
+	eval.True(err != nil)
+
+	c, err = newCompressor("gzip", 0, config.DefaultCacheCompressionTypes())
+	eval.NoErr(err)
+	eval.True(c.eligible("text/html; charset=utf-8"))
+	eval.True(c.eligible("application/json"))
+	eval.True(!c.eligible("image/png"))
+	eval.True(!c.eligible("application/jsonx"))
+	eval.True(!c.eligible(""))
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	eval := is.New(t)
+
+	testcases := map[string]struct {
+		acceptEncoding string
+		want           bool
+	}{
+		"no header":             {"", false},
+		"listed":                {"deflate, gzip", true},
+		"listed in other case":  {"GZIP", true},
+		"with quality":          {"gzip;q=0.5", true},
+		"refused":               {"gzip;q=0", false},
+		"wildcard":              {"*", true},
+		"wildcard but refused":  {"*, gzip;q=0", false},
+		"refused wildcard only": {"*;q=0, deflate", false},
+		"other encodings only":  {"br, zstd", false},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			h := http.Header{}
+			if tc.acceptEncoding != "" {
+				h.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+
+			eval.Equal(acceptsEncoding(h, "gzip"), tc.want)
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package reverseproxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var errNoOperation = errors.New("graphql document has no operation to execute")
+
+// graphqlOperationType returns the type of the operation of the GraphQL
+// document query that a request for operationName executes: query,
+// mutation or subscription. It only tokenizes the document as far as needed
+// to find the operation definitions, without validating it.
+func graphqlOperationType(query, operationName string) (string, error) {
+	type operation struct {
+		typ, name string
+	}
+
+	var ops []operation
+
+	lex := graphqlLexer{src: query}
+	depth := 0
+	expectDefinition := true
+
+	for {
+		tok, err := lex.next()
+		if err != nil {
+			return "", err
+		}
+
+		if tok == "" {
+			break
+		}
+
+		switch tok {
+		case "{", "(", "[":
+			if expectDefinition && tok == "{" {
+				// The query shorthand is an anonymous query.
+				ops = append(ops, operation{typ: "query"})
+			}
+
+			expectDefinition = false
+			depth++
+
+			continue
+		case "}", ")", "]":
+			if depth--; depth < 0 {
+				return "", errors.New("unbalanced graphql document")
+			}
+
+			// A definition ends with its selection set.
+			expectDefinition = depth == 0 && tok == "}"
+
+			continue
+		}
+
+		if !expectDefinition {
+			continue
+		}
+
+		expectDefinition = false
+
+		switch tok {
+		case "query", "mutation", "subscription":
+			op := operation{typ: tok}
+
+			if name, ok := lex.peekName(); ok {
+				op.name = name
+			}
+
+			ops = append(ops, op)
+		case "fragment":
+		default:
+			// Type system definitions and extensions aren't executable.
+			return "", fmt.Errorf("unexpected %q in graphql document", tok)
+		}
+	}
+
+	if depth != 0 {
+		return "", errors.New("unbalanced graphql document")
+	}
+
+	if operationName == "" {
+		if len(ops) != 1 {
+			return "", errNoOperation
+		}
+
+		return ops[0].typ, nil
+	}
+
+	for _, op := range ops {
+		if op.name == operationName {
+			return op.typ, nil
+		}
+	}
+
+	return "", errNoOperation
+}
+
+// graphqlLexer splits a GraphQL document into names and punctuators,
+// skipping ignored tokens, strings and numbers.
+type graphqlLexer struct {
+	src string
+	pos int
+}
+
+// next returns the next name or punctuator, or an empty string at the end
+// of the document.
+func (l *graphqlLexer) next() (string, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			if end := strings.IndexAny(l.src[l.pos:], "\r\n"); end >= 0 {
+				l.pos += end
+			} else {
+				l.pos = len(l.src)
+			}
+		case c == '"':
+			if err := l.skipString(); err != nil {
+				return "", err
+			}
+		case isNameStart(c):
+			start := l.pos
+			for l.pos < len(l.src) && isNameContinue(l.src[l.pos]) {
+				l.pos++
+			}
+
+			return l.src[start:l.pos], nil
+		case c == '-' || (c >= '0' && c <= '9'):
+			// Numbers can't hold braces or names the definitions depend on.
+			l.pos++
+			for l.pos < len(l.src) && (isNameContinue(l.src[l.pos]) || l.src[l.pos] == '.' || l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+				l.pos++
+			}
+		default:
+			l.pos++
+
+			return string(c), nil
+		}
+	}
+
+	return "", nil
+}
+
+// peekName consumes the next token if it is a name.
+func (l *graphqlLexer) peekName() (string, bool) {
+	pos := l.pos
+
+	tok, err := l.next()
+	if err == nil && tok != "" && isNameStart(tok[0]) {
+		return tok, true
+	}
+
+	l.pos = pos
+
+	return "", false
+}
+
+func (l *graphqlLexer) skipString() error {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		l.pos += 3
+
+		for l.pos < len(l.src) {
+			switch {
+			case strings.HasPrefix(l.src[l.pos:], `\"""`):
+				l.pos += 4
+			case strings.HasPrefix(l.src[l.pos:], `"""`):
+				l.pos += 3
+
+				return nil
+			default:
+				l.pos++
+			}
+		}
+
+		return errors.New("unterminated graphql block string")
+	}
+
+	l.pos++
+
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+		case '"':
+			l.pos++
+
+			return nil
+		case '\n', '\r':
+			return errors.New("unterminated graphql string")
+		default:
+			l.pos++
+		}
+	}
+
+	return errors.New("unterminated graphql string")
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// maxPersistedQueries bounds the persisted query IDs remembered.
+const maxPersistedQueries = 10000
+
+// persistedQueries remembers the IDs of the persisted queries seen along
+// with their text, as requests carrying only the ID can't tell whether they
+// execute a query or a mutation.
+type persistedQueries struct {
+	mu      sync.Mutex
+	queries map[string]bool
+}
+
+func (pq *persistedQueries) add(id string) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	// Forgetting the IDs only makes their requests uncached until they are
+	// seen with their text again.
+	if pq.queries == nil || len(pq.queries) >= maxPersistedQueries {
+		pq.queries = make(map[string]bool)
+	}
+
+	pq.queries[id] = true
+}
+
+func (pq *persistedQueries) isQuery(id string) bool {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	return pq.queries[id]
+}
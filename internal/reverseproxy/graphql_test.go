@@ -0,0 +1,62 @@
+package reverseproxy
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestGraphQLOperationType(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		want          string
+		wantErr       bool
+	}{
+		{name: "shorthand", query: `{ user(id: 1) { name } }`, want: "query"},
+		{name: "anonymous query", query: `query { user { name } }`, want: "query"},
+		{name: "named query", query: `query GetUser($id: ID = "{") { user(id: $id) { name } }`, want: "query"},
+		{name: "mutation", query: `mutation { deleteUser(id: 1) }`, want: "mutation"},
+		{name: "subscription", query: `subscription OnEvent { event { id } }`, want: "subscription"},
+		{name: "directives", query: `query Q @cached(ttl: 10) { a }`, want: "query"},
+		{name: "variables with object default", query: `query Q($f: Filter = {a: [1, 2]}) { a }`, want: "query"},
+		{
+			name:  "fragments",
+			query: "fragment F on User { name }\n# mutation in a comment\nquery Q { user { ...F } }",
+			want:  "query",
+		},
+		{
+			name:          "selected query",
+			query:         `query A { a } mutation B { b }`,
+			operationName: "A",
+			want:          "query",
+		},
+		{
+			name:          "selected mutation",
+			query:         `query A { a } mutation B { b }`,
+			operationName: "B",
+			want:          "mutation",
+		},
+		{
+			name:  "strings",
+			query: `query { a(s: "mutation { x }", b: """block "quoted" \""" mutation""") }`,
+			want:  "query",
+		},
+		{name: "ambiguous", query: `query A { a } query B { b }`, wantErr: true},
+		{name: "missing operation", query: `query A { a }`, operationName: "B", wantErr: true},
+		{name: "unbalanced", query: `query { a `, wantErr: true},
+		{name: "type definition", query: `type User { name: String }`, wantErr: true},
+		{name: "unterminated string", query: `{ a(s: "x) }`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			typ, err := graphqlOperationType(tt.query, tt.operationName)
+			eval.Equal(err != nil, tt.wantErr)
+			eval.Equal(typ, tt.want)
+		})
+	}
+}
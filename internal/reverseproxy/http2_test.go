@@ -0,0 +1,293 @@
+package reverseproxy
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func h2cProtocols() *http.Protocols {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &protocols
+}
+
+func TestH2C(t *testing.T) {
+	eval := is.New(t)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write([]byte(r.Proto + " te=" + r.Header.Get("Te")))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	upstream.Config.Protocols = h2cProtocols()
+	upstream.Start()
+	defer upstream.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: upstream.URL,
+			Transport: config.TransportConfig{H2C: true},
+		},
+	})
+	eval.NoErr(err)
+
+	// The proxy accepts h2c as its plain listener does with
+	// PROXY_SERVER_H2C.
+	proxysrv := httptest.NewUnstartedServer(rproxy)
+	proxysrv.Config.Protocols = h2cProtocols()
+	proxysrv.Start()
+	defer proxysrv.Close()
+
+	client := &http.Client{Transport: &http.Transport{Protocols: h2cProtocols()}}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequest(http.MethodGet, proxysrv.URL+"/rpc", nil)
+	eval.NoErr(err)
+	req.Header.Set("Te", "trailers")
+
+	resp, err := client.Do(req)
+	eval.NoErr(err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	eval.NoErr(err)
+
+	eval.Equal(resp.Proto, "HTTP/2.0")
+	eval.Equal(string(body), "HTTP/2.0 te=trailers")
+	eval.Equal(resp.Trailer.Get("Grpc-Status"), "0")
+
+	// Records can't hold trailers.
+	eval.True(strings.HasSuffix(resp.Header.Get("Cache-Status"), "detail=trailers"))
+}
+
+func TestStreaming(t *testing.T) {
+	eval := is.New(t)
+
+	next := make(chan struct{})
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+
+		// Each message waits for the client to get the one before.
+		for _, msg := range []string{"first", "second"} {
+			_, _ = w.Write([]byte(msg))
+			_ = http.NewResponseController(w).Flush()
+
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	upstream.Config.Protocols = h2cProtocols()
+	upstream.Start()
+	defer upstream.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: upstream.URL,
+			Transport: config.TransportConfig{H2C: true, MaxInFlight: 1},
+		},
+	})
+	eval.NoErr(err)
+
+	proxysrv := httptest.NewUnstartedServer(rproxy)
+	proxysrv.Config.Protocols = h2cProtocols()
+	proxysrv.Start()
+	defer proxysrv.Close()
+
+	client := &http.Client{Transport: &http.Transport{Protocols: h2cProtocols()}}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequest(http.MethodPost, proxysrv.URL+"/pkg.Service/Watch", strings.NewReader("request"))
+	eval.NoErr(err)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	resp, err := client.Do(req)
+	eval.NoErr(err)
+	defer resp.Body.Close()
+
+	read := func(n int) string {
+		buf := make([]byte, n)
+		done := make(chan error, 1)
+
+		go func() {
+			_, err := io.ReadFull(resp.Body, buf)
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			eval.NoErr(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not streamed")
+		}
+
+		return string(buf)
+	}
+
+	eval.Equal(read(len("first")), "first")
+	next <- struct{}{}
+	eval.Equal(read(len("second")), "second")
+	next <- struct{}{}
+
+	rest, err := io.ReadAll(resp.Body)
+	eval.NoErr(err)
+	eval.Equal(len(rest), 0)
+	eval.Equal(resp.Trailer.Get("Grpc-Status"), "0")
+
+	// The stream released its slot, so another request goes through.
+	go func() { next <- struct{}{}; next <- struct{}{} }()
+
+	rec := httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	eval.Equal(rec.Body.String(), "firstsecond")
+}
+
+func TestHTTP2Upstream(t *testing.T) {
+	eval := is.New(t)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	eval.NoErr(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0o600))
+
+	for _, http2 := range []bool{false, true} {
+		rproxy, err := New(&config.Config{
+			Proxy: config.ProxyConfig{
+				TargetURL: upstream.URL,
+				Transport: config.TransportConfig{
+					HTTP2: http2,
+					TLS:   config.UpstreamTLSConfig{CAFile: caFile},
+				},
+			},
+		})
+		eval.NoErr(err)
+
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if http2 {
+			eval.Equal(rec.Body.String(), "HTTP/2.0")
+		} else {
+			eval.Equal(rec.Body.String(), "HTTP/1.1")
+		}
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	eval := is.New(t)
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	upstream.Config.Protocols = h2cProtocols()
+	upstream.Start()
+	defer upstream.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: upstream.URL,
+			Transport: config.TransportConfig{H2C: true, MaxInFlight: 2},
+		},
+	})
+	eval.NoErr(err)
+
+	var wg sync.WaitGroup
+	var ok atomic.Int32
+
+	for i := range 6 {
+		wg.Go(func() {
+			// Distinct paths so that no request is answered from the cache.
+			rec := httptest.NewRecorder()
+			rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+strconv.Itoa(i), nil))
+
+			if rec.Code == http.StatusOK {
+				ok.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	eval.Equal(ok.Load(), int32(6))
+	eval.True(maxInFlight <= 2)
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	eval := is.New(t)
+
+	// Without a Connection header, as in HTTP/2 requests.
+	h := http.Header{
+		"Keep-Alive": {"timeout=5"},
+		"Te":         {"trailers"},
+		"Upgrade":    {"websocket"},
+		"Accept":     {"*/*"},
+	}
+	removeHopByHopHeaders(h)
+	eval.Equal(h, http.Header{"Accept": {"*/*"}})
+
+	h = http.Header{
+		"Connection": {"close, X-Hop"},
+		"X-Hop":      {"1"},
+		"Accept":     {"*/*"},
+	}
+	removeHopByHopHeaders(h)
+	eval.Equal(h, http.Header{"Accept": {"*/*"}})
+}
+
+func TestAcceptsTrailers(t *testing.T) {
+	tests := []struct {
+		te   []string
+		want bool
+	}{
+		{te: nil, want: false},
+		{te: []string{"trailers"}, want: true},
+		{te: []string{"gzip;q=0.5, Trailers"}, want: true},
+		{te: []string{"gzip", "trailers;q=1"}, want: true},
+		{te: []string{"gzip"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.te, "|"), func(t *testing.T) {
+			eval := is.New(t)
+
+			eval.Equal(acceptsTrailers(http.Header{"Te": tt.te}), tt.want)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package reverseproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// userPartition keys the responses that may not be shared, such as private
+// ones, by the user who requested them.
+type userPartition struct {
+	// header carries the identity of the user, set by a trusted
+	// authentication layer in front of the proxy. The Authorization
+	// header, or else the verified client certificate, identifies the user
+	// when it is empty.
+	header string
+}
+
+func newUserPartition(enabled bool, header string) *userPartition {
+	if !enabled {
+		return nil
+	}
+
+	return &userPartition{header: header}
+}
+
+// key returns the key of the partition of the user who sent r for the
+// record under key, or an empty string if r carries no identity.
+func (up *userPartition) key(key string, r *http.Request) string {
+	if up == nil {
+		return ""
+	}
+
+	var identity string
+	if up.header != "" {
+		identity = r.Header.Get(up.header)
+	} else if identity = r.Header.Get("Authorization"); identity == "" {
+		if cert := verifiedClientCert(r); cert != nil {
+			identity = "cert:" + certFingerprint(cert)
+		}
+	}
+
+	if identity == "" {
+		return ""
+	}
+
+	// The identity is hashed so that credentials don't appear in keys.
+	sum := sha256.Sum256([]byte(identity))
+
+	return variantKey(key, "user", hex.EncodeToString(sum[:]))
+}
+
+// variantKey returns the key of a variant of the record under key, such as
+// a user's, named by kind and value. The separator is a NUL byte, which keys
+// composed from a request can't hold: Go rejects control characters in
+// request targets and header values, so no URL can forge a variant's key.
+func variantKey(key, kind, value string) string {
+	return key + "\x00" + kind + ":" + value
+}
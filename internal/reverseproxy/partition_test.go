@@ -0,0 +1,228 @@
+package reverseproxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestSharedBypassReason(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		cert          bool
+		cacheControl  string
+		want          string
+	}{
+		{name: "anonymous", want: ""},
+		{name: "anonymous private", cacheControl: "private, max-age=60", want: "private"},
+		{name: "authorized", authorization: "Bearer a", want: "authorization"},
+		{name: "authorized max-age", authorization: "Bearer a", cacheControl: "max-age=60", want: "authorization"},
+		{name: "authorized public", authorization: "Bearer a", cacheControl: "public, max-age=60", want: ""},
+		{name: "authorized s-maxage", authorization: "Bearer a", cacheControl: "s-maxage=60", want: ""},
+		{name: "authorized must-revalidate", authorization: "Bearer a", cacheControl: "max-age=60, Must-Revalidate", want: ""},
+		{name: "authorized private", authorization: "Bearer a", cacheControl: "private, s-maxage=60", want: "private"},
+		{name: "directive argument", authorization: "Bearer a", cacheControl: `no-cache="public"`, want: "authorization"},
+		{name: "client certificate", cert: true, cacheControl: "max-age=60", want: "client-cert"},
+		{name: "client certificate public", cert: true, cacheControl: "public, max-age=60", want: ""},
+		{name: "client certificate private", cert: true, cacheControl: "private", want: "private"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			if tt.cert {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+			}
+
+			resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+			if tt.cacheControl != "" {
+				resp.Header.Set("Cache-Control", tt.cacheControl)
+			}
+
+			eval.Equal(sharedBypassReason(req, resp), tt.want)
+		})
+	}
+}
+
+func TestUserPartition(t *testing.T) {
+	var upstreamCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			_, _ = w.Write([]byte("everyone"))
+
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		_, _ = w.Write([]byte("hello " + r.Header.Get("Authorization") + r.Header.Get("X-User")))
+	}))
+	defer srv.Close()
+
+	newProxy := func(t *testing.T, header string) *ReverseProxy {
+		rproxy, err := New(&config.Config{
+			Proxy: config.ProxyConfig{
+				TargetURL: srv.URL,
+			},
+			Cache: config.CacheConfig{
+				TTL:                 5 * time.Minute,
+				MaxSize:             1 * 1024 * 1024,
+				MaxRecordSize:       1024,
+				UserPartition:       true,
+				UserPartitionHeader: header,
+			},
+		})
+		is.New(t).NoErr(err)
+
+		return rproxy
+	}
+
+	get := func(rproxy *ReverseProxy, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, vals := range header {
+			req.Header[name] = vals
+		}
+
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("authorization", func(t *testing.T) {
+		eval := is.New(t)
+		upstreamCalls.Store(0)
+
+		rproxy := newProxy(t, "")
+		alice := http.Header{"Authorization": {"alice"}}
+		bob := http.Header{"Authorization": {"bob"}}
+
+		eval.Equal(get(rproxy, "/me", alice).Body.String(), "hello alice")
+		eval.Equal(get(rproxy, "/me", bob).Body.String(), "hello bob")
+
+		rec := get(rproxy, "/me", alice)
+		eval.Equal(rec.Body.String(), "hello alice")
+		eval.True(strings.Contains(rec.Header().Get("Cache-Status"), "; hit"))
+
+		eval.Equal(get(rproxy, "/me", bob).Body.String(), "hello bob")
+		eval.Equal(upstreamCalls.Load(), int32(2))
+
+		// private responses to anonymous requests aren't stored
+		rec = get(rproxy, "/me", nil)
+		eval.Equal(rec.Body.String(), "hello ")
+		eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "detail=private"))
+
+		// public responses are shared between users
+		get(rproxy, "/public", alice)
+		eval.Equal(get(rproxy, "/public", bob).Body.String(), "everyone")
+		eval.Equal(get(rproxy, "/public", nil).Body.String(), "everyone")
+		eval.Equal(upstreamCalls.Load(), int32(4))
+	})
+
+	t.Run("header", func(t *testing.T) {
+		eval := is.New(t)
+		upstreamCalls.Store(0)
+
+		rproxy := newProxy(t, "X-User")
+
+		get(rproxy, "/me", http.Header{"X-User": {"alice"}, "Authorization": {"token-1"}})
+
+		// the identity is the header, whatever the credentials
+		rec := get(rproxy, "/me", http.Header{"X-User": {"alice"}, "Authorization": {"token-2"}})
+		eval.Equal(rec.Body.String(), "hello token-1alice")
+
+		eval.Equal(get(rproxy, "/me", http.Header{"X-User": {"bob"}}).Body.String(), "hello bob")
+		eval.Equal(upstreamCalls.Load(), int32(2))
+	})
+}
+
+func TestUserPartitionForgedKey(t *testing.T) {
+	eval := is.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = w.Write([]byte("query " + r.URL.RawQuery))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:                 5 * time.Minute,
+			MaxSize:             1 * 1024 * 1024,
+			MaxRecordSize:       1024,
+			UserPartition:       true,
+			UserPartitionHeader: "X-User",
+		},
+	})
+	eval.NoErr(err)
+
+	// An anonymous request whose query spells alice's partition key for /p?q.
+	sum := sha256.Sum256([]byte("alice"))
+	rec := httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/p?q|user:"+hex.EncodeToString(sum[:]), nil))
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "stored"))
+
+	req := httptest.NewRequest(http.MethodGet, "/p?q", nil)
+	req.Header.Set("X-User", "alice")
+	rec = httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, req)
+	eval.Equal(rec.Body.String(), "query q")
+}
+
+func TestUserPartitionDisabled(t *testing.T) {
+	eval := is.New(t)
+
+	var upstreamCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	eval.NoErr(err)
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer a")
+
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, req)
+		eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "detail=authorization"))
+	}
+
+	eval.Equal(upstreamCalls.Load(), int32(2))
+}
@@ -0,0 +1,174 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/routes"
+)
+
+var errMutation = errors.New("graphql operation is not a query")
+
+// postBodyKey is the context key of the body of a POST request that may be
+// cached.
+type postBodyKey struct{}
+
+// postBody returns the body of a POST request that may be cached.
+func postBody(ctx context.Context) ([]byte, bool) {
+	body, ok := ctx.Value(postBodyKey{}).([]byte)
+
+	return body, ok
+}
+
+// cacheableMethod reports whether the responses to r may be cached given
+// its method. POST requests may be if postCacheKey accepted them.
+func cacheableMethod(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		_, ok := postBody(r.Context())
+
+		return ok
+	}
+
+	return false
+}
+
+// postCacheKey reads the body of a POST request for a route caching them and
+// returns the request, with its body restored, and its cache key: key with
+// a hash of the normalized body. If the request may not be cached, it
+// returns why instead of the key.
+func (p *ReverseProxy) postCacheKey(post *routes.PostCache, key string, r *http.Request) (*http.Request, string, string) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !slices.Contains(post.ContentTypes, mediaType) || r.ContentLength > int64(post.MaxBodySize) {
+		return r, "", "body"
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(post.MaxBodySize)+1))
+
+	// The body is forwarded whole whether or not the request is cached.
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+	if err != nil || len(body) > post.MaxBodySize {
+		return r, "", "body"
+	}
+
+	var normalized []byte
+
+	switch {
+	case post.GraphQL:
+		normalized, err = p.normalizeGraphQL(body)
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		normalized, err = canonicalJSON(body)
+	default:
+		normalized = body
+	}
+
+	if errors.Is(err, errMutation) {
+		return r, "", "mutation"
+	} else if err != nil {
+		return r, "", "body"
+	}
+
+	sum := sha256.Sum256(normalized)
+	r = r.WithContext(context.WithValue(r.Context(), postBodyKey{}, body))
+
+	return r, variantKey(key, "body", hex.EncodeToString(sum[:])), ""
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// canonicalJSON returns data with insignificant whitespace removed and object
+// members sorted by name, so that equivalent documents compare equal.
+// Numbers are kept as written.
+func canonicalJSON(data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after json value")
+	}
+
+	return json.Marshal(v)
+}
+
+// graphqlRequest is a GraphQL request as sent over HTTP, possibly using
+// automatic persisted queries.
+type graphqlRequest struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+	Extensions    struct {
+		PersistedQuery struct {
+			Sha256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// normalizeGraphQL returns what identifies a GraphQL query request: the ID
+// of its document, the SHA-256 persisted queries are identified by, its
+// operation name and its canonical variables. It returns errMutation for
+// requests executing anything but a query. Requests for a persisted query
+// whose text wasn't seen with a query yet are refused too.
+func (p *ReverseProxy) normalizeGraphQL(body []byte) ([]byte, error) {
+	var req graphqlRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	id := strings.ToLower(req.Extensions.PersistedQuery.Sha256Hash)
+
+	if req.Query != "" {
+		sum := sha256.Sum256([]byte(req.Query))
+		if queryID := hex.EncodeToString(sum[:]); id == "" {
+			id = queryID
+		} else if id != queryID {
+			return nil, errors.New("persisted query id doesn't match the query")
+		}
+
+		typ, err := graphqlOperationType(req.Query, req.OperationName)
+		if err != nil {
+			return nil, err
+		}
+
+		if typ != "query" {
+			return nil, errMutation
+		}
+
+		// Only documents executing a single query are remembered, as the
+		// ID doesn't say which operation of the document is executed.
+		if _, err := graphqlOperationType(req.Query, ""); err == nil {
+			p.persisted.add(id)
+		}
+	} else if id == "" || !p.persisted.isQuery(id) {
+		return nil, errors.New("unknown persisted query")
+	}
+
+	variables := []byte("null")
+	if len(req.Variables) > 0 {
+		var err error
+		if variables, err = canonicalJSON(req.Variables); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal([]any{id, req.OperationName, json.RawMessage(variables)})
+}
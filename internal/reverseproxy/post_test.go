@@ -0,0 +1,148 @@
+package reverseproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: `{"b": 1, "a": [2, {"d": 1, "c": 1.50}]}`, want: `{"a":[2,{"c":1.50,"d":1}],"b":1}`},
+		{in: ` "x" `, want: `"x"`},
+		{in: `{"a": 12345678901234567890}`, want: `{"a":12345678901234567890}`},
+		{in: `{"a": 1} {}`, wantErr: true},
+		{in: `{"a":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			eval := is.New(t)
+
+			got, err := canonicalJSON([]byte(tt.in))
+			eval.Equal(err != nil, tt.wantErr)
+
+			if !tt.wantErr {
+				eval.Equal(string(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestPostCache(t *testing.T) {
+	eval := is.New(t)
+
+	var upstreamCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	routesFile := filepath.Join(t.TempDir(), "routes.json")
+	eval.NoErr(os.WriteFile(routesFile, []byte(`{
+		"routes": [
+			{"path": "/search", "post": {"maxBodySize": 64}},
+			{"path": "/graphql", "post": {"graphql": true}}
+		]
+	}`), 0o600))
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL:  srv.URL,
+			RoutesFile: routesFile,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	eval.NoErr(err)
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	calls := func() int32 {
+		return upstreamCalls.Load()
+	}
+
+	// equivalent JSON bodies share a record
+	rec := post("/search", "application/json", `{"q": "go", "page": 1}`)
+	eval.Equal(rec.Body.String(), `{"q": "go", "page": 1}`)
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "stored"))
+
+	rec = post("/search", "application/json; charset=utf-8", `{"page":1,"q":"go"}`)
+	eval.Equal(rec.Body.String(), `{"q": "go", "page": 1}`)
+	eval.Equal(calls(), int32(1))
+
+	post("/search", "application/json", `{"q": "rust", "page": 1}`)
+	eval.Equal(calls(), int32(2))
+
+	// bodies that are too large or of another type are forwarded whole
+	large := `{"q": "` + strings.Repeat("x", 100) + `"}`
+	rec = post("/search", "application/json", large)
+	eval.Equal(rec.Body.String(), large)
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "fwd=bypass; detail=body"))
+
+	rec = post("/search", "text/plain", "q=go")
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "fwd=bypass; detail=body"))
+	eval.Equal(calls(), int32(4))
+
+	// POST isn't cached outside the routes enabling it
+	post("/other", "application/json", `{}`)
+	rec = post("/other", "application/json", `{}`)
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "fwd=bypass; detail=method"))
+	eval.Equal(calls(), int32(6))
+
+	// GraphQL queries are cached, mutations never
+	query := `query User($id: ID!) { user(id: $id) { name } }`
+	sum := sha256.Sum256([]byte(query))
+	id := hex.EncodeToString(sum[:])
+
+	post("/graphql", "application/json", `{"query": "`+query+`", "variables": {"id": 1}}`)
+	eval.Equal(calls(), int32(7))
+
+	// the persisted query is known by its ID now
+	rec = post("/graphql", "application/json", `{"variables": {"id": 1}, "extensions": {"persistedQuery": {"version": 1, "sha256Hash": "`+id+`"}}}`)
+	eval.True(strings.Contains(rec.Header().Get("Cache-Status"), "; hit"))
+	eval.Equal(calls(), int32(7))
+
+	post("/graphql", "application/json", `{"query": "`+query+`", "variables": {"id": 2}}`)
+	eval.Equal(calls(), int32(8))
+
+	for range 2 {
+		rec = post("/graphql", "application/json", `{"query": "mutation { deleteUser(id: 1) }"}`)
+		eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "fwd=bypass; detail=mutation"))
+	}
+
+	rec = post("/graphql", "application/json", `{"extensions": {"persistedQuery": {"sha256Hash": "unknown"}}}`)
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "fwd=bypass; detail=body"))
+
+	eval.Equal(calls(), int32(11))
+}
@@ -0,0 +1,232 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxRanges is the number of ranges past which a Range header is ignored
+// and the full body is sent instead.
+const maxRanges = 100
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// byteRange is a range of a body, as start and length.
+type byteRange struct {
+	start, length int
+}
+
+func (br byteRange) contentRange(size int) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// writeCachedResponse writes the body of a cached response with the given
+// status code to rw, its headers already set. A HEAD request gets the headers
+// only, and a Range request on a complete response gets the ranges it asks
+// for.
+func writeCachedResponse(rw http.ResponseWriter, r *http.Request, statusCode int, body []byte) error {
+	h := rw.Header()
+
+	if r.Method == http.MethodHead {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		rw.WriteHeader(statusCode)
+
+		return nil
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if statusCode != http.StatusOK || rangeHeader == "" || !ifRangeMatches(r.Header.Get("If-Range"), h) {
+		rw.WriteHeader(statusCode)
+
+		_, err := rw.Write(body)
+
+		return err
+	}
+
+	ranges, err := parseRange(rangeHeader, len(body))
+	switch {
+	case errors.Is(err, errUnsatisfiableRange):
+		h.Del("Content-Length")
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+		rw.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+
+		return nil
+	case len(ranges) == 0:
+		rw.WriteHeader(statusCode)
+
+		_, err := rw.Write(body)
+
+		return err
+	case len(ranges) == 1:
+		br := ranges[0]
+
+		h.Set("Content-Range", br.contentRange(len(body)))
+		h.Set("Content-Length", strconv.Itoa(br.length))
+		rw.WriteHeader(http.StatusPartialContent)
+
+		_, err := rw.Write(body[br.start : br.start+br.length])
+
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	mw := multipart.NewWriter(&buf)
+	for _, br := range ranges {
+		part := textproto.MIMEHeader{"Content-Range": {br.contentRange(len(body))}}
+		if contentType := h.Get("Content-Type"); contentType != "" {
+			part.Set("Content-Type", contentType)
+		}
+
+		w, err := mw.CreatePart(part)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(body[br.start : br.start+br.length]); err != nil {
+			return err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	rw.WriteHeader(http.StatusPartialContent)
+
+	_, err = rw.Write(buf.Bytes())
+
+	return err
+}
+
+// parseRange parses a Range header for a body of size bytes, as RFC 9110
+// section 14.2 describes. It returns no ranges and no error when the header
+// is to be ignored, and errUnsatisfiableRange when none of its ranges
+// overlaps the body.
+func parseRange(s string, size int) ([]byteRange, error) {
+	unit, spec, ok := strings.Cut(s, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+
+	total := 0
+	seen := false
+
+	for ra := range strings.SplitSeq(spec, ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		seen = true
+
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var br byteRange
+
+		if first == "" {
+			// A suffix range holds the last bytes of the body.
+			n, err := strconv.Atoi(last)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+
+			if n == 0 || size == 0 {
+				continue
+			}
+
+			n = min(n, size)
+			br = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.Atoi(first)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.Atoi(last); err != nil || end < start {
+					return nil, nil
+				}
+			}
+
+			if start >= size {
+				continue
+			}
+
+			br = byteRange{start: start, length: min(end, size-1) - start + 1}
+		}
+
+		total += br.length
+		ranges = append(ranges, br)
+	}
+
+	if !seen {
+		return nil, nil
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+
+	// Overlapping or too many ranges would make the response larger than
+	// the body, so the body is sent whole instead.
+	if len(ranges) > maxRanges || total > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether the If-Range condition of a request holds
+// against the headers h of the cached response. An entity tag must match
+// strongly, and a date must equal Last-Modified.
+func ifRangeMatches(ifRange string, h http.Header) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		etag := h.Get("ETag")
+
+		return !strings.HasPrefix(ifRange, "W/") && etag == ifRange
+	}
+
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+
+	return err == nil && t.Equal(lastModified)
+}
+
+// withoutRange returns r without its Range and If-Range headers.
+func withoutRange(r *http.Request) *http.Request {
+	if r.Header.Get("Range") == "" {
+		return r
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Del("Range")
+	r.Header.Del("If-Range")
+
+	return r
+}
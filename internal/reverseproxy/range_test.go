@@ -0,0 +1,207 @@
+package reverseproxy
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{header: "bytes=0-4", want: []byteRange{{0, 5}}},
+		{header: "bytes=5-", want: []byteRange{{5, 5}}},
+		{header: "bytes=-3", want: []byteRange{{7, 3}}},
+		{header: "bytes=-20", want: []byteRange{{0, 10}}},
+		{header: "bytes=8-20", want: []byteRange{{8, 2}}},
+		{header: "bytes=0-1, 4-5", want: []byteRange{{0, 2}, {4, 2}}},
+		{header: "bytes=0-1,,20-30", want: []byteRange{{0, 2}}},
+		{header: "Bytes = 2-2", want: []byteRange{{2, 1}}},
+		{header: "bytes=10-", err: errUnsatisfiableRange},
+		{header: "bytes=-0", err: errUnsatisfiableRange},
+		// ignored
+		{header: "items=0-1"},
+		{header: "bytes="},
+		{header: "bytes=abc"},
+		{header: "bytes=5-2"},
+		{header: "bytes=0-9,0-9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			eval := is.New(t)
+
+			ranges, err := parseRange(tt.header, 10)
+			eval.Equal(err, tt.err)
+			eval.Equal(ranges, tt.want)
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	h := http.Header{
+		"Etag":          {`"v1"`},
+		"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"},
+	}
+
+	tests := []struct {
+		ifRange string
+		want    bool
+	}{
+		{ifRange: "", want: true},
+		{ifRange: `"v1"`, want: true},
+		{ifRange: `"v2"`, want: false},
+		{ifRange: `W/"v1"`, want: false},
+		{ifRange: "Mon, 02 Jan 2006 15:04:05 GMT", want: true},
+		{ifRange: "Tue, 03 Jan 2006 15:04:05 GMT", want: false},
+		{ifRange: "yesterday", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ifRange, func(t *testing.T) {
+			is.New(t).Equal(ifRangeMatches(tt.ifRange, h), tt.want)
+		})
+	}
+}
+
+func TestCachedHeadAndRange(t *testing.T) {
+	eval := is.New(t)
+
+	const text = "0123456789abcdefghij"
+
+	var upstreamCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(text))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	eval.NoErr(err)
+
+	serve := func(method string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/file", nil)
+		for name, vals := range header {
+			req.Header[name] = vals
+		}
+
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// a HEAD miss is forwarded but not stored
+	rec := serve(http.MethodHead, nil)
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "detail=method"))
+
+	rec = serve(http.MethodGet, nil)
+	eval.Equal(rec.Body.String(), text)
+	eval.Equal(upstreamCalls.Load(), int32(2))
+
+	rec = serve(http.MethodHead, nil)
+	eval.Equal(rec.Code, http.StatusOK)
+	eval.Equal(rec.Header().Get("Content-Length"), "20")
+	eval.Equal(rec.Body.Len(), 0)
+
+	rec = serve(http.MethodGet, http.Header{"Range": {"bytes=2-4"}})
+	eval.Equal(rec.Code, http.StatusPartialContent)
+	eval.Equal(rec.Header().Get("Content-Range"), "bytes 2-4/20")
+	eval.Equal(rec.Header().Get("Content-Length"), "3")
+	eval.Equal(rec.Body.String(), "234")
+
+	rec = serve(http.MethodGet, http.Header{"Range": {"bytes=30-"}})
+	eval.Equal(rec.Code, http.StatusRequestedRangeNotSatisfiable)
+	eval.Equal(rec.Header().Get("Content-Range"), "bytes */20")
+
+	// If-Range that doesn't match gets the full body
+	rec = serve(http.MethodGet, http.Header{"Range": {"bytes=2-4"}, "If-Range": {`"v0"`}})
+	eval.Equal(rec.Code, http.StatusOK)
+	eval.Equal(rec.Body.String(), text)
+
+	rec = serve(http.MethodGet, http.Header{"Range": {"bytes=0-1,-2"}, "If-Range": {`"v1"`}})
+	eval.Equal(rec.Code, http.StatusPartialContent)
+
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	eval.NoErr(err)
+	eval.Equal(mediaType, "multipart/byteranges")
+
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+
+	for _, want := range []struct{ contentRange, body string }{
+		{"bytes 0-1/20", "01"},
+		{"bytes 18-19/20", "ij"},
+	} {
+		part, err := mr.NextPart()
+		eval.NoErr(err)
+		eval.Equal(part.Header.Get("Content-Range"), want.contentRange)
+		eval.Equal(part.Header.Get("Content-Type"), "text/plain")
+
+		body, err := io.ReadAll(part)
+		eval.NoErr(err)
+		eval.Equal(string(body), want.body)
+	}
+
+	_, err = mr.NextPart()
+	eval.Equal(err, io.EOF)
+
+	eval.Equal(upstreamCalls.Load(), int32(2))
+}
+
+func TestPartialKeyForged(t *testing.T) {
+	eval := is.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("query "+r.URL.RawQuery))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	eval.NoErr(err)
+
+	// A plain request whose query spells the key of a range of /p?x.
+	rec := httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/p?x|range:bytes=-1", nil))
+	eval.Equal(rec.Code, http.StatusOK)
+
+	req := httptest.NewRequest(http.MethodGet, "/p?x", nil)
+	req.Header.Set("Range", "bytes=-1")
+	rec = httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, req)
+	eval.Equal(rec.Code, http.StatusPartialContent)
+	eval.Equal(rec.Body.String(), "x")
+}
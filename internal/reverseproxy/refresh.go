@@ -0,0 +1,273 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/routes"
+)
+
+// refresher decides when a hot record is refreshed ahead of its expiry and
+// bounds the refreshes running at once.
+type refresher struct {
+	// fraction is the part of a record's lifetime, at its end, within which
+	// hits may trigger a refresh.
+	fraction float64
+	// minHits is the number of hits within that window needed to trigger it.
+	minHits int
+	timeout time.Duration
+	sem     chan struct{}
+
+	mu     sync.Mutex
+	states map[string]*refreshState
+	// pruneAt is the number of states past which expired ones are dropped.
+	pruneAt int
+
+	wg sync.WaitGroup
+}
+
+type refreshState struct {
+	// expiry identifies the record the hits were counted for.
+	expiry   time.Time
+	hits     int
+	inFlight bool
+}
+
+func newRefresher(fraction float64, minHits, concurrency int, timeout time.Duration) (*refresher, error) {
+	if fraction == 0 {
+		return nil, nil
+	}
+
+	if fraction < 0 || fraction > 1 {
+		return nil, fmt.Errorf("cache refresh-ahead fraction %v is not between 0 and 1", fraction)
+	}
+
+	return &refresher{
+		fraction: fraction,
+		minHits:  max(minHits, 1),
+		timeout:  timeout,
+		sem:      make(chan struct{}, max(concurrency, 1)),
+		states:   make(map[string]*refreshState),
+		pruneAt:  1024,
+	}, nil
+}
+
+// hit counts a hit on the record stored under key and reports whether it
+// should be refreshed now. When it returns true, a refresh slot is held
+// until done is called for key.
+func (rf *refresher) hit(key string, rec *cache.Record, now time.Time) bool {
+	if rf == nil {
+		return false
+	}
+
+	ttl := rec.TTL(now)
+	lifetime := rec.Age(now) + ttl
+
+	if ttl <= 0 || float64(ttl) > rf.fraction*float64(lifetime) {
+		return false
+	}
+
+	expiry := now.Add(ttl)
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	s, ok := rf.states[key]
+	if !ok {
+		rf.prune(now)
+
+		s = &refreshState{expiry: expiry}
+		rf.states[key] = s
+	} else if !s.inFlight && !s.expiry.Equal(expiry) {
+		// The record was replaced since the hits were counted.
+		*s = refreshState{expiry: expiry}
+	}
+
+	s.hits++
+
+	if s.inFlight || s.hits < rf.minHits {
+		return false
+	}
+
+	select {
+	case rf.sem <- struct{}{}:
+	default:
+		return false
+	}
+
+	s.inFlight = true
+
+	return true
+}
+
+// done releases the refresh slot held for key.
+func (rf *refresher) done(key string) {
+	rf.mu.Lock()
+	delete(rf.states, key)
+	rf.mu.Unlock()
+
+	<-rf.sem
+}
+
+// prune drops the states of expired records once there are pruneAt of them,
+// doubling the threshold if most are still live so that pruning stays
+// amortized. It is called with mu held.
+func (rf *refresher) prune(now time.Time) {
+	if len(rf.states) < rf.pruneAt {
+		return
+	}
+
+	for k, s := range rf.states {
+		if !s.inFlight && !s.expiry.After(now) {
+			delete(rf.states, k)
+		}
+	}
+
+	rf.pruneAt = max(rf.pruneAt, 2*len(rf.states))
+}
+
+// wait blocks until the refreshes in flight are done.
+func (rf *refresher) wait() {
+	if rf != nil {
+		rf.wg.Wait()
+	}
+}
+
+// conditionalHeaders make a request conditional on the representation the
+// client already holds.
+var conditionalHeaders = []string{
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// removeCredentials removes the credentials of the client from h, including
+// the identity of its certificate forwarded by cc, but those the cache key ck
+// is made of, which the record is already specific to.
+func removeCredentials(h http.Header, ck *routes.CacheKey, up *userPartition, cc *clientCerts) {
+	var keyHeaders, keyCookies []string
+	if ck != nil {
+		keyHeaders, keyCookies = ck.Headers, ck.Cookies
+	}
+
+	inKey := func(name string) bool {
+		return slices.ContainsFunc(keyHeaders, func(h string) bool {
+			return strings.EqualFold(h, name)
+		})
+	}
+
+	names := []string{"Authorization"}
+	if up != nil && up.header != "" {
+		names = append(names, up.header)
+	}
+
+	names = append(names, cc.headers()...)
+
+	for _, name := range names {
+		if !inKey(name) {
+			h.Del(name)
+		}
+	}
+
+	if inKey("Cookie") || h.Get("Cookie") == "" {
+		return
+	}
+
+	var kept []string
+
+	for _, c := range (&http.Request{Header: h}).Cookies() {
+		if slices.Contains(keyCookies, c.Name) {
+			kept = append(kept, c.String())
+		}
+	}
+
+	h.Del("Cookie")
+
+	if len(kept) > 0 {
+		h.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// refresh fetches the response to r, whose hit on the record under hitKey
+// triggered the refresh, from the upstream in the background. It stores the
+// response under key, or userKey if it may not be shared, replacing the
+// record there. A shared record is refreshed without the credentials of the
+// client whose hit triggered it, so it is never stored under userKey then.
+func (p *ReverseProxy) refresh(route *routes.Route, hitKey, key, userKey string, r *http.Request) {
+	rf := p.refresher
+
+	// The refresh outlives the request it was triggered by.
+	ctx, cancel := context.WithoutCancel(r.Context()), context.CancelFunc(func() {})
+	if rf.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, rf.timeout)
+	}
+
+	req := r.Clone(ctx)
+	req.Body = http.NoBody
+
+	if body, ok := postBody(ctx); ok {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+
+	// A conditional request would get a 304 rather than a record to store.
+	for _, h := range conditionalHeaders {
+		req.Header.Del(h)
+	}
+
+	if hitKey != userKey {
+		removeCredentials(req.Header, route.CacheKey, p.partition, p.clientCerts)
+		req.TLS = nil
+		userKey = ""
+	}
+
+	// A HEAD request is refreshed with the GET request the record is
+	// stored for.
+	if req.Method == http.MethodHead {
+		req.Method = http.MethodGet
+	}
+
+	rf.wg.Go(func() {
+		defer rf.done(hitKey)
+		defer cancel()
+
+		resp, err := p.fetch(req)
+		if err != nil {
+			slog.Error("failed to refresh cached record", "key", key, "error", err)
+
+			return
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+
+		var status cacheStatus
+
+		storeKey, reason := p.storeKey(req, key, userKey, resp)
+		if storeKey == "" {
+			status.detail = reason
+		} else {
+			body, err := readBody(resp)
+			if err != nil {
+				slog.Error("failed to refresh cached record", "key", key, "error", err)
+
+				return
+			}
+
+			p.store(req, storeKey, resp, body, &status)
+		}
+
+		slog.Debug("Refreshed cached record", "key", key, "stored", status.stored, "reason", status.detail)
+	})
+}
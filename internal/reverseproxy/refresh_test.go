@@ -0,0 +1,260 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	eval := is.New(t)
+
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:                500 * time.Millisecond,
+			MaxSize:            1 * 1024 * 1024,
+			MaxRecordSize:      1024,
+			RefreshAhead:       0.8,
+			RefreshMinHits:     2,
+			RefreshConcurrency: 1,
+		},
+	})
+	eval.NoErr(err)
+
+	get := func() {
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		eval.Equal(rec.Body.String(), "hello")
+	}
+
+	get()
+	eval.Equal(calls.Load(), int32(1))
+
+	// hits before the refresh window don't count
+	get()
+	get()
+	time.Sleep(20 * time.Millisecond)
+	eval.Equal(calls.Load(), int32(1))
+
+	time.Sleep(150 * time.Millisecond)
+
+	before, err := rproxy.Cache.Get(context.Background(), "GET:/")
+	eval.NoErr(err)
+
+	// one hit within the window isn't enough
+	get()
+	time.Sleep(20 * time.Millisecond)
+	eval.Equal(calls.Load(), int32(1))
+
+	get()
+	rproxy.refresher.wait()
+	eval.Equal(calls.Load(), int32(2))
+
+	after, err := rproxy.Cache.Get(context.Background(), "GET:/")
+	eval.NoErr(err)
+	eval.True(after.TTL(time.Now()) > before.TTL(time.Now()))
+
+	eval.NoErr(rproxy.Close())
+}
+
+func TestRefreshAheadRequestHeaders(t *testing.T) {
+	eval := is.New(t)
+
+	var calls atomic.Int32
+	var refreshHeader atomic.Pointer[http.Header]
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n > 1 {
+			refreshHeader.Store(&r.Header)
+		}
+
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("v" + strconv.Itoa(int(n))))
+	}))
+	defer srv.Close()
+
+	routesFile := filepath.Join(t.TempDir(), "routes.json")
+	eval.NoErr(os.WriteFile(routesFile, []byte(`{"routes": [{"path": "/", "cacheKey": {"cookies": ["currency"]}}]}`), 0o600))
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL:  srv.URL,
+			RoutesFile: routesFile,
+		},
+		Cache: config.CacheConfig{
+			TTL:                time.Minute,
+			MaxSize:            1 * 1024 * 1024,
+			MaxRecordSize:      1024,
+			RefreshAhead:       1,
+			RefreshMinHits:     1,
+			RefreshConcurrency: 1,
+		},
+	})
+	eval.NoErr(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", "currency=EUR")
+	rproxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The client revalidating its copy triggers the refresh.
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", "session=secret; currency=EUR")
+	req.Header.Set("If-None-Match", `"v1"`)
+	rproxy.ServeHTTP(httptest.NewRecorder(), req)
+	rproxy.refresher.wait()
+
+	eval.Equal(calls.Load(), int32(2))
+
+	h := *refreshHeader.Load()
+	eval.Equal(h.Get("If-None-Match"), "")
+	eval.Equal(h.Get("Cookie"), "currency=EUR")
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", "currency=EUR")
+	rec := httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, req)
+	eval.Equal(rec.Body.String(), "v2")
+
+	eval.NoErr(rproxy.Close())
+}
+
+func TestRefreshAheadLimits(t *testing.T) {
+	eval := is.New(t)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first response of every path is cached, refreshes then block
+		if calls.Add(1) > 2 {
+			<-release
+		}
+
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:                time.Minute,
+			MaxSize:            1 * 1024 * 1024,
+			MaxRecordSize:      1024,
+			RefreshAhead:       1,
+			RefreshMinHits:     1,
+			RefreshConcurrency: 1,
+		},
+	})
+	eval.NoErr(err)
+
+	get := func(path string) {
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		eval.Equal(rec.Body.String(), "hello")
+	}
+
+	get("/a")
+	get("/b")
+	eval.Equal(calls.Load(), int32(2))
+
+	// refreshes are deduplicated per key and bounded globally
+	for range 10 {
+		get("/a")
+		get("/b")
+	}
+
+	eval.True(waitFor(func() bool { return calls.Load() == 3 }))
+	time.Sleep(20 * time.Millisecond)
+	eval.Equal(calls.Load(), int32(3))
+
+	close(release)
+	rproxy.refresher.wait()
+
+	// the slot is free again
+	get("/b")
+	rproxy.refresher.wait()
+	eval.Equal(calls.Load(), int32(4))
+
+	eval.NoErr(rproxy.Close())
+}
+
+func TestRefresherHit(t *testing.T) {
+	now := time.Now()
+
+	record := func(age, ttl time.Duration) *cache.Record {
+		r := cache.Record{StatusCode: http.StatusOK}
+		r.Stamp(now.Add(-age), age+ttl)
+
+		return &r
+	}
+
+	tests := []struct {
+		name    string
+		age     time.Duration
+		ttl     time.Duration
+		hits    int
+		refresh bool
+	}{
+		{name: "outside window", age: 10 * time.Second, ttl: 50 * time.Second, hits: 5, refresh: false},
+		{name: "too few hits", age: 50 * time.Second, ttl: 10 * time.Second, hits: 1, refresh: false},
+		{name: "hot and expiring", age: 50 * time.Second, ttl: 10 * time.Second, hits: 2, refresh: true},
+		{name: "expired", age: time.Minute, ttl: 0, hits: 5, refresh: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			rf, err := newRefresher(0.25, 2, 1, 0)
+			eval.NoErr(err)
+
+			rec := record(tt.age, tt.ttl)
+
+			refresh := false
+			for range tt.hits {
+				refresh = rf.hit("key", rec, now)
+			}
+
+			eval.Equal(refresh, tt.refresh)
+		})
+	}
+}
+
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,40 +2,174 @@ package reverseproxy
 
 import (
 	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/komaldsukhani/reverseproxyexample/internal/cache"
 	"github.com/komaldsukhani/reverseproxyexample/internal/config"
 	"github.com/komaldsukhani/reverseproxyexample/internal/memcache"
+	"github.com/komaldsukhani/reverseproxyexample/internal/routes"
+	"github.com/komaldsukhani/reverseproxyexample/internal/tlsconfig"
 )
 
 type ReverseProxy struct {
-	targetURL string
-	Cache     *memcache.MemoryCache
-	transport *http.Transport
+	targetURL   string
+	Cache       cache.Store
+	cacheTTL    time.Duration
+	transport   *http.Transport
+	compress    *compressor
+	refresher   *refresher
+	routes      *routes.Table
+	statuses    *cacheableStatuses
+	persisted   persistedQueries
+	partition   *userPartition
+	clientCerts *clientCerts
+	// inFlight, when set, bounds the requests in flight to the upstream,
+	// whatever the protocol they are sent over.
+	inFlight chan struct{}
+
+	ageHeader         bool
+	cacheStatusHeader bool
+	cacheStatusName   string
+	xCacheHeader      bool
 }
 
-func New(config *config.Config) *ReverseProxy {
-	return &ReverseProxy{
-		targetURL: config.Proxy.TargetURL,
-		Cache:     memcache.NewMemoryCache(config.Cache.TTL, config.Cache.MaxSize, config.Cache.MaxRecordSize),
-		transport: newTransport(config),
+func New(config *config.Config) (*ReverseProxy, error) {
+	compress, err := newCompressor(config.Cache.Compression, config.Cache.CompressionMinSize, config.Cache.CompressionTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := newRefresher(config.Cache.RefreshAhead, config.Cache.RefreshMinHits, config.Cache.RefreshConcurrency, config.Cache.RefreshTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := newCacheableStatuses(config.Cache.StatusCodes, config.Cache.NegativeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	routeTable, err := routes.Load(config.Proxy.RoutesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := newClientCerts(&config.Proxy.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := cache.New(config)
+	if err != nil {
+		return nil, err
 	}
+
+	return &ReverseProxy{
+		targetURL:   config.Proxy.TargetURL,
+		Cache:       store,
+		cacheTTL:    config.Cache.TTL,
+		transport:   transport,
+		compress:    compress,
+		refresher:   refresh,
+		routes:      routeTable,
+		statuses:    statuses,
+		partition:   newUserPartition(config.Cache.UserPartition, config.Cache.UserPartitionHeader),
+		clientCerts: certs,
+		inFlight:    newSemaphore(config.Proxy.Transport.MaxInFlight),
+
+		ageHeader:         !config.Cache.DisableAgeHeader,
+		cacheStatusHeader: !config.Cache.DisableCacheStatusHeader,
+		cacheStatusName:   config.Cache.StatusName,
+		xCacheHeader:      config.Cache.XCacheHeader,
+	}, nil
 }
 
 func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	p.serve(rw, r)
+}
+
+// ServeCached serves r like ServeHTTP and reports whether the response was
+// served from the cache or stored in it.
+func (p *ReverseProxy) ServeCached(rw http.ResponseWriter, r *http.Request) bool {
+	status := p.serve(rw, r)
+
+	return status.hit || status.stored
+}
+
+// serve proxies r, answering from the cache when possible, and returns how
+// the cache handled it.
+func (p *ReverseProxy) serve(rw http.ResponseWriter, r *http.Request) cacheStatus {
+	status := cacheStatus{fwd: "miss"}
+
+	// The route is matched on the path forwarded, so that neither repeated
+	// slashes nor dot segments get around it.
+	cleanPath(r.URL)
+
+	route := p.routes.Match(r)
+
+	// The identity headers are set before the key is composed so that the
+	// route's cache key may include them.
+	if !p.clientCerts.forward(route, r) {
+		http.Error(rw, "client certificate required", http.StatusForbidden)
+
+		return status
+	}
+
+	key := route.CacheKey.Key(r)
+
+	var reason string
+	if r.Method == http.MethodPost && route.Post != nil {
+		r, key, reason = p.postCacheKey(route.Post, key, r)
+	}
+
+	rangeKey := partialKey(key, r)
+	userKey := p.partition.key(key, r)
+
 	// Check if the request can be served from cache.
-	if canServeFromCache(r) {
-		key := getCacheKey(r)
+	if reason = cmp.Or(reason, serveFromCacheBypassReason(r)); reason != "" {
+		status = cacheStatus{fwd: "bypass", detail: reason}
+	} else {
+		// A record of the user's partition takes precedence over a shared
+		// one, and without the full response, a partial one stored for the
+		// same range may answer.
+		hitKey, cachedResp, err := p.lookup(r.Context(), userKey, key, rangeKey)
+
+		var body []byte
+		var encoding string
+		if err == nil {
+			body, encoding, err = cachedBody(r, cachedResp)
+		}
+
+		if err == nil {
+			slog.Debug("Request served from the cache", "key", hitKey, "status", cachedResp.StatusCode)
 
-		cachedResp := p.Cache.Get(key)
-		if cachedResp != nil {
-			slog.Debug("Request served from the cache", "key", key, "status", cachedResp.StatusCode)
+			now := time.Now()
+
+			if p.refresher.hit(hitKey, cachedResp, now) {
+				if hitKey == rangeKey {
+					p.refresh(route, hitKey, rangeKey, "", r)
+				} else {
+					// The full record is refreshed without the range r asks for.
+					p.refresh(route, hitKey, key, userKey, withoutRange(r))
+				}
+			}
 
 			for h, vals := range cachedResp.Headers {
 				for _, v := range vals {
@@ -43,33 +177,121 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 				}
 			}
 
-			rw.WriteHeader(cachedResp.StatusCode)
+			if cachedResp.ContentEncoding != "" {
+				setEncodingHeaders(rw.Header(), encoding, len(body))
+			}
+
+			if p.ageHeader {
+				setAgeHeader(rw.Header(), cachedResp.Age(now))
+			}
+
+			p.writeCacheHeaders(rw.Header(), cacheStatus{hit: true, ttl: cachedResp.TTL(now)})
 
-			_, err := rw.Write(cachedResp.Body)
-			if err != nil {
+			if err := writeCachedResponse(rw, r, cachedResp.StatusCode, body); err != nil {
 				slog.Error("failed to write cached response body", "error", err)
 			} else {
-				return
+				return cacheStatus{hit: true}
 			}
 		} else {
 			// cache miss
-			slog.Debug("Cache miss", "key", key)
+			slog.Debug("Cache miss", "key", key, "reason", err)
+
+			if errors.Is(err, cache.ErrExpired) {
+				status.fwd = "stale"
+			}
 		}
 	}
 
-	outreq, err := http.NewRequestWithContext(r.Context(), r.Method, "", r.Body)
+	resp, err := p.fetch(r)
 	if err != nil {
-		slog.Error("failed to create new http request", "error", err)
+		slog.Error("request to upstream failed", "error", err)
 
 		http.Error(rw, "failed to handle request", http.StatusBadGateway)
-		return
+		return status
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var storeKey string
+	if r.Method == http.MethodHead {
+		// The key belongs to the GET response, which this one lacks the body of.
+		status.detail = "method"
+	} else if resp.StatusCode == http.StatusPartialContent {
+		if rangeKey != "" && status.fwd != "bypass" {
+			storeKey, status.detail = p.storeKey(r, rangeKey, "", resp)
+		} else {
+			status.detail = "status"
+		}
+	} else if status.fwd != "bypass" {
+		storeKey, status.detail = p.storeKey(r, key, userKey, resp)
 	}
 
-	if outreq.URL, err = joinURL(r.URL, p.targetURL); err != nil {
-		slog.Error("failed to join target url and request path", "error", err)
+	// A response that may be stored is read whole and stored before it is
+	// written, so that the outcome can be reported in the Cache-Status
+	// header. The others are streamed, as gRPC and server-sent events need.
+	var body []byte
+	compressed := false
+	if storeKey != "" {
+		if body, err = readBody(resp); err != nil {
+			slog.Error("request to upstream failed", "error", err)
+
+			http.Error(rw, "failed to handle request", http.StatusBadGateway)
+			return status
+		}
 
-		http.Error(rw, "failed to handle request", http.StatusBadGateway)
-		return
+		stored := p.store(r, storeKey, resp, body, &status)
+		compressed = stored && storeKey != rangeKey
+	}
+
+	for h, vals := range resp.Header {
+		for _, v := range vals {
+			rw.Header().Add(h, v)
+		}
+	}
+
+	// Later hits on a compressed record depend on Accept-Encoding.
+	if compressed {
+		setEncodingHeaders(rw.Header(), "", 0)
+	}
+
+	p.writeCacheHeaders(rw.Header(), status)
+
+	rw.WriteHeader(resp.StatusCode)
+
+	if storeKey != "" {
+		_, err = rw.Write(body)
+	} else {
+		err = stream(rw, resp.Body)
+	}
+
+	if err != nil {
+		slog.Error("failed to write response body", "error", err)
+
+		return status
+	}
+
+	// Trailers, such as gRPC's status, are sent after the body.
+	for h, vals := range resp.Trailer {
+		rw.Header()[http.TrailerPrefix+h] = vals
+	}
+
+	slog.Debug("Successfully proxied the request")
+
+	return status
+}
+
+// fetch sends r to the upstream. It returns the response without its
+// hop-by-hop headers. The request counts as in flight until the body of the
+// response is closed.
+func (p *ReverseProxy) fetch(r *http.Request) (*http.Response, error) {
+	outreq, err := http.NewRequestWithContext(r.Context(), r.Method, "", r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new http request: %w", err)
+	}
+
+	if outreq.URL, err = joinURL(r.URL, p.targetURL); err != nil {
+		return nil, fmt.Errorf("failed to join target url and request path: %w", err)
 	}
 
 	outreq.Header = r.Header.Clone()
@@ -78,64 +300,203 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	// Remove hop-by-hop headers before sending to upstream
 	removeHopByHopHeaders(outreq.Header)
 
+	if acceptsTrailers(r.Header) {
+		outreq.Header.Set("Te", "trailers")
+	}
+
+	release := func() {}
+
+	if p.inFlight != nil {
+		select {
+		case p.inFlight <- struct{}{}:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+
+		release = sync.OnceFunc(func() { <-p.inFlight })
+	}
+
 	resp, err := p.transport.RoundTrip(outreq)
 	if err != nil {
-		slog.Error("request to upstream failed", "error", err)
+		release()
 
-		http.Error(rw, "failed to handle request", http.StatusBadGateway)
-		return
+		return nil, err
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
 
+	removeHopByHopHeaders(resp.Header)
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+	return resp, nil
+}
+
+// releasingBody calls release once it is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+
+	return b.ReadCloser.Close()
+}
+
+// readBody reads the whole body of resp.
+func readBody(resp *http.Response) ([]byte, error) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		slog.Error("failed to read response body of upstream request", "error", err)
+		return nil, fmt.Errorf("failed to read response body of upstream request: %w", err)
+	}
 
-		http.Error(rw, "failed to handle request", http.StatusBadGateway)
-		return
+	return body, nil
+}
+
+// stream copies body to rw, flushing the headers first and then every read,
+// so that the client gets each message as soon as the upstream sends it.
+func stream(rw http.ResponseWriter, body io.Reader) error {
+	rc := http.NewResponseController(rw)
+
+	flush := func() error {
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+
+		return nil
 	}
 
-	removeHopByHopHeaders(resp.Header)
+	if err := flush(); err != nil {
+		return err
+	}
 
-	for h, vals := range resp.Header {
-		for _, v := range vals {
-			rw.Header().Add(h, v)
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := rw.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read response body of upstream request: %w", err)
 		}
 	}
+}
 
-	rw.WriteHeader(resp.StatusCode)
+// storeKey returns the key the response to r may be stored under: key, or
+// userKey if the response may not be shared. It returns an empty key along
+// with the reason if the response may not be stored.
+func (p *ReverseProxy) storeKey(r *http.Request, key, userKey string, resp *http.Response) (string, string) {
+	if reason := p.storeBypassReason(r, resp); reason != "" {
+		return "", reason
+	}
 
-	if _, err := rw.Write(body); err != nil {
-		slog.Error("failed to write response body", "error", err)
+	if reason := sharedBypassReason(r, resp); reason != "" {
+		if userKey == "" {
+			return "", reason
+		}
 
-		return
+		return userKey, ""
 	}
 
-	if canCacheRequest(r, resp) {
-		key := getCacheKey(r)
+	return key, ""
+}
+
+// store caches the response to r under key, as returned by storeKey,
+// recording the outcome in status. It reports whether the body was stored
+// compressed.
+func (p *ReverseProxy) store(r *http.Request, key string, resp *http.Response, body []byte, status *cacheStatus) bool {
+	slog.Debug("Caching the request", "key", key)
+
+	record := cache.Record{
+		StatusCode: resp.StatusCode,
+		Body:       bytes.Clone(body),
+		Headers:    resp.Header.Clone(),
+	}
+
+	if err := p.compress.compress(&record); err != nil {
+		slog.Error("failed to compress response body", "key", key, "error", err)
+	}
+
+	if err := p.Cache.Set(r.Context(), key, &record, p.statuses.ttl(resp.StatusCode, p.cacheTTL)); errors.Is(err, memcache.ErrMaxRecordSizeExceed) {
+		slog.Debug("failed to cache request", "error", err)
+
+		status.detail = "too-large"
+	} else if err != nil {
+		slog.Error("failed to cache request", "key", key, "error", err)
+
+		status.detail = "store-error"
+	} else {
+		slog.Debug("Request cached", "key", key, "size", record.Calsize(), "encoding", record.ContentEncoding)
+
+		status.stored = true
+	}
+
+	return status.stored && record.ContentEncoding != ""
+}
+
+// lookup returns the first record found under keys, skipping empty ones,
+// along with its key. If none is found, it returns cache.ErrExpired if one
+// of them expired, or the error of the first one.
+func (p *ReverseProxy) lookup(ctx context.Context, keys ...string) (string, *cache.Record, error) {
+	var firstErr error
 
-		slog.Debug("Caching the request", "key", key)
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
 
-		record := memcache.Record{
-			StatusCode: resp.StatusCode,
-			Body:       bytes.Clone(body),
-			Headers:    resp.Header.Clone(),
+		rec, err := p.Cache.Get(ctx, key)
+		if err == nil {
+			return key, rec, nil
 		}
 
-		if err := p.Cache.Set(key, &record); err != nil {
-			slog.Debug("failed to cache request", "error", err)
-		} else {
-			slog.Debug("Request cached", "key", key, "size", record.Calsize())
+		if firstErr == nil || errors.Is(err, cache.ErrExpired) {
+			firstErr = err
 		}
 	}
 
-	slog.Debug("Successfully proxied the request")
+	return "", nil, firstErr
+}
+
+// Close waits for the background refreshes and releases the cache. It is
+// called once the server stopped serving requests.
+func (p *ReverseProxy) Close() error {
+	p.refresher.wait()
+
+	return cache.Close(p.Cache)
 }
 
-func getCacheKey(r *http.Request) string {
-	return r.Method + ":" + r.URL.String()
+// cleanPath resolves the . and .. segments of u's path and merges its
+// repeated slashes, keeping a trailing slash.
+func cleanPath(u *url.URL) {
+	u.Path = cleanSlashes(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = cleanSlashes(u.RawPath)
+	}
+}
+
+func cleanSlashes(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
 }
 
 func joinURL(req *url.URL, targetURL string) (*url.URL, error) {
@@ -192,65 +553,112 @@ func removeHopByHopHeaders(header http.Header) {
 				header.Del(v)
 			}
 		}
+	}
+
+	for _, h := range hopByHopHeaders {
+		header.Del(h)
+	}
+}
 
-		for _, h := range hopByHopHeaders {
-			header.Del(h)
+// acceptsTrailers reports whether the TE header of h asks for trailers,
+// which gRPC requires to be forwarded.
+func acceptsTrailers(h http.Header) bool {
+	for _, vals := range h.Values("Te") {
+		for v := range strings.SplitSeq(vals, ",") {
+			if name, _, _ := strings.Cut(v, ";"); strings.EqualFold(strings.TrimSpace(name), "trailers") {
+				return true
+			}
 		}
 	}
+
+	return false
 }
 
-func canServeFromCache(r *http.Request) bool {
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
-		return false
+// serveFromCacheBypassReason returns why r must not be answered from the cache,
+// or an empty string if it may be.
+func serveFromCacheBypassReason(r *http.Request) string {
+	if !cacheableMethod(r) {
+		return "method"
 	}
 
 	h := r.Header.Get("Cache-Control")
 	// Check if the request has a "no-cache" directive
 	if strings.Contains(h, "no-cache") {
-		return false
-
+		return "no-cache"
 	}
 
-	return true
+	return ""
 }
 
-func canCacheRequest(r *http.Request, resp *http.Response) bool {
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
-		return false
-	}
-
-	if r.Header.Get("Authorization") != "" {
-		return false
+// storeBypassReason returns why resp must not be stored for r at all, or an
+// empty string if it may be, if only in a user's partition.
+func (p *ReverseProxy) storeBypassReason(r *http.Request, resp *http.Response) string {
+	if !cacheableMethod(r) {
+		return "method"
 	}
 
 	h := r.Header.Get("Cache-Control")
 	if strings.Contains(h, "no-store") {
-		return false
+		return "no-store"
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return false
+	if !p.statuses.cacheable(resp.StatusCode) {
+		return "status"
 	}
 
-	if h := resp.Header.Get("Cache-Control"); h != "" {
-		// Check if the response has a "no-cache" directive
-		if strings.Contains(h, "no-cache") {
-			return false
-		}
+	if hasDirective(resp.Header, "no-cache") {
+		return "no-cache"
+	}
 
-		if strings.Contains(h, "no-store") {
-			return false
-		}
+	if hasDirective(resp.Header, "no-store") {
+		return "no-store"
+	}
 
-		if strings.Contains(h, "private") {
-			return false
-		}
+	// Records hold no trailers.
+	if len(resp.Trailer) > 0 {
+		return "trailers"
+	}
+
+	return ""
+}
+
+// sharedBypassReason returns why resp may only be stored for the user who
+// requested it, or an empty string if it may be shared. As RFC 9111 section
+// 3.5 requires, responses to requests with credentials are only shared when
+// the upstream explicitly allows it. A verified client certificate is such a
+// credential, as its identity is forwarded to the upstream.
+func sharedBypassReason(r *http.Request, resp *http.Response) string {
+	if hasDirective(resp.Header, "private") {
+		return "private"
 	}
 
-	return true
+	var reason string
+	if r.Header.Get("Authorization") != "" {
+		reason = "authorization"
+	} else if verifiedClientCert(r) != nil {
+		reason = "client-cert"
+	}
+
+	if reason != "" &&
+		!hasDirective(resp.Header, "public") &&
+		!hasDirective(resp.Header, "s-maxage") &&
+		!hasDirective(resp.Header, "must-revalidate") {
+		return reason
+	}
+
+	return ""
 }
 
-func newTransport(config *config.Config) *http.Transport {
+func newTransport(config *config.Config) (*http.Transport, error) {
+	tlsConfig, err := tlsconfig.NewClient(&config.Proxy.Transport.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Proxy.Transport.TLS.InsecureSkipVerify {
+		slog.Warn("Verification of the upstream's tls certificate is disabled")
+	}
+
 	return &http.Transport{
 		DialContext: (&net.Dialer{
 			Timeout:   config.Proxy.Transport.DialTimeout,
@@ -260,5 +668,32 @@ func newTransport(config *config.Config) *http.Transport {
 		MaxIdleConns:        config.Proxy.Transport.MaxIdleConnections,
 		MaxIdleConnsPerHost: config.Proxy.Transport.MaxIdleConnsPerHost,
 		IdleConnTimeout:     config.Proxy.Transport.IdleConnTimeout,
+
+		TLSClientConfig: tlsConfig,
+		Protocols:       transportProtocols(&config.Proxy.Transport),
+	}, nil
+}
+
+// transportProtocols returns the protocols spoken to the upstream, or nil
+// for HTTP/1 only. With H2C, HTTP/2 is spoken without TLS from the start,
+// since Go doesn't upgrade connections to h2c.
+func transportProtocols(config *config.TransportConfig) *http.Protocols {
+	if !config.HTTP2 && !config.H2C {
+		return nil
 	}
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(!config.H2C)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(config.H2C)
+
+	return &protocols
+}
+
+func newSemaphore(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(chan struct{}, n)
 }
@@ -1,11 +1,17 @@
 package reverseproxy
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/pem"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -26,7 +32,7 @@ func TestCachedRequest(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	rproxy := New(&config.Config{
+	rproxy, err := New(&config.Config{
 		Proxy: config.ProxyConfig{
 			TargetURL: srv.URL,
 		},
@@ -34,8 +40,13 @@ func TestCachedRequest(t *testing.T) {
 			TTL:           5 * time.Minute,
 			MaxSize:       1 * 1024 * 1024,
 			MaxRecordSize: 1024,
+
+			// the cache headers differ between a miss and a hit
+			DisableAgeHeader:         true,
+			DisableCacheStatusHeader: true,
 		},
 	})
+	eval.NoErr(err)
 
 	proxysrv := httptest.NewServer(rproxy)
 	defer proxysrv.Close()
@@ -88,19 +99,22 @@ func TestCacheNonSupportedMethods(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	rproxy := New(&config.Config{
+	rproxy, err := New(&config.Config{
 		Proxy: config.ProxyConfig{
 			TargetURL: srv.URL,
 		},
 	})
+	eval.NoErr(err)
 
 	proxysrv := httptest.NewServer(rproxy)
 	defer proxysrv.Close()
 
-	_, err := http.Post(proxysrv.URL, "", nil)
+	_, err = http.Post(proxysrv.URL, "", nil)
 	eval.NoErr(err)
 
-	eval.True(rproxy.Cache.Count() == 0)
+	stats, err := rproxy.Cache.Stats(context.Background())
+	eval.NoErr(err)
+	eval.Equal(stats.Records, 0)
 }
 
 func TestCachedNonSupportedResponseCode(t *testing.T) {
@@ -108,7 +122,7 @@ func TestCachedNonSupportedResponseCode(t *testing.T) {
 
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/protected" {
-			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
@@ -116,19 +130,22 @@ func TestCachedNonSupportedResponseCode(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	rproxy := New(&config.Config{
+	rproxy, err := New(&config.Config{
 		Proxy: config.ProxyConfig{
 			TargetURL: srv.URL,
 		},
 	})
+	eval.NoErr(err)
 
 	proxysrv := httptest.NewServer(rproxy)
 	defer proxysrv.Close()
 
-	_, err := http.Get(proxysrv.URL + "/protected")
+	_, err = http.Get(proxysrv.URL + "/protected")
 	eval.NoErr(err)
 
-	eval.True(rproxy.Cache.Count() == 0)
+	stats, err := rproxy.Cache.Stats(context.Background())
+	eval.NoErr(err)
+	eval.Equal(stats.Records, 0)
 }
 
 func TestCacheTTL(t *testing.T) {
@@ -142,7 +159,7 @@ func TestCacheTTL(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	rproxy := New(&config.Config{
+	rproxy, err := New(&config.Config{
 		Proxy: config.ProxyConfig{
 			TargetURL: srv.URL,
 		},
@@ -150,11 +167,12 @@ func TestCacheTTL(t *testing.T) {
 			TTL: 30 * time.Second,
 		},
 	})
+	eval.NoErr(err)
 
 	proxysrv := httptest.NewServer(rproxy)
 	defer proxysrv.Close()
 
-	_, err := http.Get(proxysrv.URL)
+	_, err = http.Get(proxysrv.URL)
 	eval.NoErr(err)
 
 	time.Sleep(30 * time.Second)
@@ -165,6 +183,230 @@ func TestCacheTTL(t *testing.T) {
 	eval.Equal(atomic.LoadInt32(&upstreamCalls), int32(2))
 }
 
+func TestCacheHeaders(t *testing.T) {
+	eval := is.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private")
+		}
+
+		w.Header().Set("Age", "5")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+			StatusName:    "proxy",
+			XCacheHeader:  true,
+		},
+	})
+	eval.NoErr(err)
+
+	proxysrv := httptest.NewServer(rproxy)
+	defer proxysrv.Close()
+
+	testcases := []struct {
+		name            string
+		method          string
+		path            string
+		wantCacheStatus string
+		wantXCache      string
+		wantAge         string
+	}{
+		{"first request is stored", http.MethodGet, "/", "proxy; fwd=miss; stored", "MISS", "5"},
+		{"second request is a hit", http.MethodGet, "/", "proxy; hit; ttl=299", "HIT", "5"},
+		{"private response is not stored", http.MethodGet, "/private", "proxy; fwd=miss; detail=private", "MISS", "5"},
+		{"post request bypasses the cache", http.MethodPost, "/", "proxy; fwd=bypass; detail=method", "MISS", "5"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, proxysrv.URL+tc.path, nil)
+			eval.NoErr(err)
+
+			resp, err := http.DefaultClient.Do(req)
+			eval.NoErr(err)
+			defer func() { _ = resp.Body.Close() }()
+
+			eval.Equal(resp.Header.Get("Cache-Status"), tc.wantCacheStatus)
+			eval.Equal(resp.Header.Get("X-Cache"), tc.wantXCache)
+			eval.Equal(resp.Header.Get("Age"), tc.wantAge)
+		})
+	}
+}
+
+func TestCacheDefaultsStoreRealisticResponse(t *testing.T) {
+	eval := is.New(t)
+
+	body := make([]byte, 16*1024)
+	_, _ = rand.Read(body)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("ETag", `"5f2b8e1c-4000"`)
+		w.Header().Set("Last-Modified", "Tue, 04 Aug 2026 12:00:00 GMT")
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Header().Set("X-Request-Id", "0f8fad5b-d9cb-469f-a165-70867728950e")
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	cfg := config.Config{Proxy: config.ProxyConfig{TargetURL: srv.URL}}
+	cfg.SetDefaults()
+
+	rproxy, err := New(&cfg)
+	eval.NoErr(err)
+
+	rec := httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images/hero.jpg?w=1200", nil))
+
+	eval.Equal(rec.Header().Get("Cache-Status"), config.DefaultCacheStatusName+"; fwd=miss; stored")
+	eval.Equal(rec.Body.Bytes(), body)
+}
+
+func TestCacheStatusStale(t *testing.T) {
+	eval := is.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL: srv.URL,
+		},
+		Cache: config.CacheConfig{
+			TTL:           10 * time.Millisecond,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+			StatusName:    "proxy",
+		},
+	})
+	eval.NoErr(err)
+
+	rec := httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	eval.Equal(rec.Header().Get("Cache-Status"), "proxy; fwd=miss; stored")
+
+	time.Sleep(20 * time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	eval.Equal(rec.Header().Get("Cache-Status"), "proxy; fwd=stale; stored")
+}
+
+func TestCacheKeyRoutes(t *testing.T) {
+	eval := is.New(t)
+
+	var upstreamCalls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.RawQuery))
+	}))
+	defer srv.Close()
+
+	routesFile := filepath.Join(t.TempDir(), "routes.json")
+	eval.NoErr(os.WriteFile(routesFile, []byte(`{
+		"routes": [
+			{"path": "/search", "cacheKey": {"excludeQuery": ["utm_*"], "sortQuery": true}}
+		]
+	}`), 0o600))
+
+	rproxy, err := New(&config.Config{
+		Proxy: config.ProxyConfig{
+			TargetURL:  srv.URL,
+			RoutesFile: routesFile,
+		},
+		Cache: config.CacheConfig{
+			TTL:           5 * time.Minute,
+			MaxSize:       1 * 1024 * 1024,
+			MaxRecordSize: 1024,
+		},
+	})
+	eval.NoErr(err)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rproxy.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+
+		return rec
+	}
+
+	// a HEAD miss isn't stored under the GET key
+	rec := serve(http.MethodHead, "/search?a=1&b=2")
+	eval.True(strings.HasSuffix(rec.Header().Get("Cache-Status"), "detail=method"))
+
+	rec = serve(http.MethodGet, "/search?a=1&b=2")
+	eval.Equal(rec.Body.String(), "GET a=1&b=2")
+
+	rec = serve(http.MethodGet, "/search?b=2&utm_source=mail&a=1")
+	eval.Equal(rec.Body.String(), "GET a=1&b=2")
+	eval.True(strings.Contains(rec.Header().Get("Cache-Status"), "; hit"))
+
+	rec = serve(http.MethodHead, "/search?b=2&a=1")
+	eval.True(strings.Contains(rec.Header().Get("Cache-Status"), "; hit"))
+
+	eval.Equal(upstreamCalls.Load(), int32(2))
+
+	// outside the route, the key is the URL as received
+	serve(http.MethodGet, "/other?a=1&b=2")
+	serve(http.MethodGet, "/other?b=2&a=1")
+	eval.Equal(upstreamCalls.Load(), int32(4))
+}
+
+func TestHTTPSUpstream(t *testing.T) {
+	eval := is.New(t)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secure"))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	eval.NoErr(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+
+	newProxy := func(tlsConfig config.UpstreamTLSConfig) (*ReverseProxy, error) {
+		return New(&config.Config{
+			Proxy: config.ProxyConfig{
+				TargetURL: srv.URL,
+				Transport: config.TransportConfig{TLS: tlsConfig},
+			},
+		})
+	}
+
+	// The test server's certificate isn't trusted by default.
+	rproxy, err := newProxy(config.UpstreamTLSConfig{})
+	eval.NoErr(err)
+
+	rec := httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	eval.Equal(rec.Code, http.StatusBadGateway)
+
+	rproxy, err = newProxy(config.UpstreamTLSConfig{CAFile: caFile})
+	eval.NoErr(err)
+
+	rec = httptest.NewRecorder()
+	rproxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	eval.Equal(rec.Code, http.StatusOK)
+	eval.Equal(rec.Body.String(), "secure")
+
+	_, err = newProxy(config.UpstreamTLSConfig{PinnedSPKI: []string{"invalid"}})
+	eval.True(err != nil)
+}
+
 func TestJoinURL(t *testing.T) {
 	eval := is.New(t)
 
@@ -195,23 +437,34 @@ func TestJoinURL(t *testing.T) {
 	}
 }
 
-func TestCanCacheRequest(t *testing.T) {
+func TestStoreKey(t *testing.T) {
 	eval := is.New(t)
 
+	statuses, err := newCacheableStatuses(nil, config.DefaultCacheNegativeTTL)
+	eval.NoErr(err)
+
+	p := &ReverseProxy{statuses: statuses}
+
 	testcases := map[string]struct {
-		method       string
-		headers      http.Header
-		statusCode   int
-		wantCanCache bool
+		method     string
+		headers    http.Header
+		statusCode int
+		userKey    string
+		wantKey    string
+		wantReason string
 	}{
-		"GET request with 200 OK":                 {method: http.MethodGet, statusCode: http.StatusOK, wantCanCache: true},
-		"HEAD request with 200 OK":                {method: http.MethodHead, statusCode: http.StatusOK, wantCanCache: true},
-		"GET request with 404 Not Found":          {method: http.MethodGet, statusCode: http.StatusNotFound, wantCanCache: false},
-		"POST request with 200 OK":                {method: http.MethodPost, statusCode: http.StatusOK, wantCanCache: false},
-		"GET request with no-store cache control": {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"no-store"}}, statusCode: http.StatusOK, wantCanCache: false},
-		"GET request with no-cache cache control": {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"no-cache"}}, statusCode: http.StatusOK, wantCanCache: false},
-		"Get request with private cache control":  {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"private"}}, statusCode: http.StatusOK, wantCanCache: false},
-		"GET request with Authorization header":   {method: http.MethodGet, headers: http.Header{"Authorization": []string{"Bearer token"}}, statusCode: http.StatusOK, wantCanCache: true},
+		"GET request with 200 OK":                 {method: http.MethodGet, statusCode: http.StatusOK, wantKey: "key"},
+		"HEAD request with 200 OK":                {method: http.MethodHead, statusCode: http.StatusOK, wantKey: "key"},
+		"GET request with 404 Not Found":          {method: http.MethodGet, statusCode: http.StatusNotFound, wantKey: "key"},
+		"GET request with 500 Internal Error":     {method: http.MethodGet, statusCode: http.StatusInternalServerError, wantReason: "status"},
+		"GET request with 302 Found":              {method: http.MethodGet, statusCode: http.StatusFound, wantReason: "status"},
+		"POST request with 200 OK":                {method: http.MethodPost, statusCode: http.StatusOK, wantReason: "method"},
+		"GET request with no-store cache control": {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"no-store"}}, statusCode: http.StatusOK, wantReason: "no-store"},
+		"GET request with no-cache cache control": {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"no-cache"}}, statusCode: http.StatusOK, wantReason: "no-cache"},
+		"Get request with private cache control":  {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"private"}}, statusCode: http.StatusOK, wantReason: "private"},
+		"GET request with private for a user":     {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"private"}}, statusCode: http.StatusOK, userKey: "user", wantKey: "user"},
+		"GET request with no-store for a user":    {method: http.MethodGet, headers: http.Header{"Cache-Control": []string{"no-store"}}, statusCode: http.StatusOK, userKey: "user", wantReason: "no-store"},
+		"GET request with Authorization header":   {method: http.MethodGet, headers: http.Header{"Authorization": []string{"Bearer token"}}, statusCode: http.StatusOK, wantKey: "key"},
 	}
 
 	for name, tc := range testcases {
@@ -228,8 +481,9 @@ func TestCanCacheRequest(t *testing.T) {
 				resp.Header = tc.headers.Clone()
 			}
 
-			canCache := canCacheRequest(req, resp)
-			eval.Equal(canCache, tc.wantCanCache)
+			key, reason := p.storeKey(req, "key", tc.userKey, resp)
+			eval.Equal(key, tc.wantKey)
+			eval.Equal(reason, tc.wantReason)
 		})
 	}
 }
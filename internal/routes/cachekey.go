@@ -0,0 +1,171 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// CacheKey describes how the cache key of a request is composed. Query
+// parameter names in IncludeQuery and ExcludeQuery may end with * to match
+// every name with that prefix, as in utm_*.
+type CacheKey struct {
+	// IncludeQuery lists the query parameters kept in the key. All are kept
+	// when it is empty.
+	IncludeQuery []string `json:"includeQuery,omitempty"`
+	// ExcludeQuery lists query parameters left out of the key.
+	ExcludeQuery []string `json:"excludeQuery,omitempty"`
+	// SortQuery sorts the query parameters by name, so that their order
+	// doesn't matter. Values of the same parameter keep their order.
+	SortQuery bool `json:"sortQuery,omitempty"`
+
+	// Headers and Cookies list the request headers and cookies whose values
+	// are added to the key.
+	Headers []string `json:"headers,omitempty"`
+	Cookies []string `json:"cookies,omitempty"`
+
+	LowercasePath     bool `json:"lowercasePath,omitempty"`
+	TrimTrailingSlash bool `json:"trimTrailingSlash,omitempty"`
+}
+
+func (k *CacheKey) validate() error {
+	if k == nil {
+		return nil
+	}
+
+	for _, name := range slices.Concat(k.IncludeQuery, k.ExcludeQuery, k.Headers, k.Cookies) {
+		if name == "" {
+			return errors.New("cache key lists an empty name")
+		}
+	}
+
+	return nil
+}
+
+// Key returns the cache key of r. Without a template, it is made of the
+// method and the URL as received. HEAD requests share the key of the GET
+// request, whose record holds their headers.
+func (k *CacheKey) Key(r *http.Request) string {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+
+	if k == nil {
+		return method + ":" + r.URL.String()
+	}
+
+	var b strings.Builder
+
+	b.WriteString(method)
+	b.WriteByte(':')
+	b.WriteString(k.path(r.URL))
+
+	if query := k.query(r.URL.RawQuery); query != "" {
+		b.WriteByte('?')
+		b.WriteString(query)
+	}
+
+	for _, name := range k.Headers {
+		b.WriteString("|header:")
+		b.WriteString(strings.ToLower(name))
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(strings.Join(r.Header.Values(name), ",")))
+	}
+
+	for _, name := range k.Cookies {
+		value := ""
+		if c, err := r.Cookie(name); err == nil {
+			value = c.Value
+		}
+
+		b.WriteString("|cookie:")
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(value))
+	}
+
+	return b.String()
+}
+
+func (k *CacheKey) path(u *url.URL) string {
+	path := u.EscapedPath()
+
+	if k.LowercasePath {
+		path = strings.ToLower(path)
+	}
+
+	if k.TrimTrailingSlash && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	return path
+}
+
+// query filters and sorts the parameters of rawQuery, keeping their
+// encoding as received.
+func (k *CacheKey) query(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	type param struct {
+		name string
+		raw  string
+	}
+
+	var params []param
+
+	for raw := range strings.SplitSeq(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if len(k.IncludeQuery) > 0 && !matchName(k.IncludeQuery, name) {
+			continue
+		}
+
+		if matchName(k.ExcludeQuery, name) {
+			continue
+		}
+
+		params = append(params, param{name: name, raw: raw})
+	}
+
+	if k.SortQuery {
+		slices.SortStableFunc(params, func(a, b param) int {
+			return strings.Compare(a.name, b.name)
+		})
+	}
+
+	raws := make([]string, len(params))
+	for i, p := range params {
+		raws[i] = p.raw
+	}
+
+	return strings.Join(raws, "&")
+}
+
+func matchName(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+
+	return false
+}
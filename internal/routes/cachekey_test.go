@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    *CacheKey
+		method string
+		target string
+		header http.Header
+		want   string
+	}{
+		{
+			name:   "default",
+			method: http.MethodGet,
+			target: "/Search/?b=2&a=1",
+			want:   "GET:/Search/?b=2&a=1",
+		},
+		{
+			name:   "sorted query",
+			key:    &CacheKey{SortQuery: true},
+			method: http.MethodGet,
+			target: "/search?b=2&a=1&b=1",
+			want:   "GET:/search?a=1&b=2&b=1",
+		},
+		{
+			name:   "excluded query",
+			key:    &CacheKey{ExcludeQuery: []string{"utm_*", "fbclid"}},
+			method: http.MethodGet,
+			target: "/search?utm_source=x&q=go&fbclid=1&utm_medium=y",
+			want:   "GET:/search?q=go",
+		},
+		{
+			name:   "included query",
+			key:    &CacheKey{IncludeQuery: []string{"q", "page"}},
+			method: http.MethodGet,
+			target: "/search?session=1&page=2&q=go%20lang",
+			want:   "GET:/search?page=2&q=go%20lang",
+		},
+		{
+			name:   "encoded query name",
+			key:    &CacheKey{ExcludeQuery: []string{"utm_source"}},
+			method: http.MethodGet,
+			target: "/search?utm%5Fsource=x&q=go",
+			want:   "GET:/search?q=go",
+		},
+		{
+			name:   "no query left",
+			key:    &CacheKey{ExcludeQuery: []string{"utm_*"}},
+			method: http.MethodGet,
+			target: "/search?utm_source=x",
+			want:   "GET:/search",
+		},
+		{
+			name:   "normalized path",
+			key:    &CacheKey{LowercasePath: true, TrimTrailingSlash: true},
+			method: http.MethodGet,
+			target: "/Search//",
+			want:   "GET:/search",
+		},
+		{
+			name:   "root path",
+			key:    &CacheKey{TrimTrailingSlash: true},
+			method: http.MethodGet,
+			target: "/",
+			want:   "GET:/",
+		},
+		{
+			name:   "headers and cookies",
+			key:    &CacheKey{Headers: []string{"Accept-Language", "X-Missing"}, Cookies: []string{"currency", "missing"}},
+			method: http.MethodGet,
+			target: "/",
+			header: http.Header{
+				"Accept-Language": {"en-US"},
+				"Cookie":          {"session=1; currency=EUR"},
+			},
+			want: "GET:/|header:accept-language=en-US|header:x-missing=|cookie:currency=EUR|cookie:missing=",
+		},
+		{
+			name:   "default head",
+			method: http.MethodHead,
+			target: "/a?b=1",
+			want:   "GET:/a?b=1",
+		},
+		{
+			name:   "head",
+			key:    &CacheKey{},
+			method: http.MethodHead,
+			target: "/a",
+			want:   "GET:/a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			for name, vals := range tt.header {
+				r.Header[name] = vals
+			}
+
+			eval.Equal(tt.key.Key(r), tt.want)
+		})
+	}
+}
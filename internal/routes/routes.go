@@ -0,0 +1,167 @@
+// Package routes holds the settings that apply to requests by path, read
+// from a JSON routes file.
+package routes
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Route holds the settings of the requests whose path is Path or below it,
+// comparing whole segments and ignoring case: /api matches /API/x but not
+// /apiary.
+type Route struct {
+	Path string `json:"path"`
+	// CacheKey composes the cache keys of the route's requests. The method
+	// and the URL as received are used when it is nil.
+	CacheKey *CacheKey `json:"cacheKey,omitempty"`
+	// Post, when set, caches the route's POST requests by their body.
+	Post *PostCache `json:"post,omitempty"`
+	// ClientCert is whether the route requires a TLS client certificate:
+	// ClientCertRequire, ClientCertOptional or ClientCertNone. The
+	// listener's default applies when it is empty.
+	ClientCert string `json:"clientCert,omitempty"`
+}
+
+// Client certificate modes of a route. The identity of verified client
+// certificates is forwarded to the upstream unless the mode is
+// ClientCertNone.
+const (
+	ClientCertRequire  = "require"
+	ClientCertOptional = "optional"
+	ClientCertNone     = "none"
+)
+
+// ValidClientCert reports whether mode is a client certificate mode.
+func ValidClientCert(mode string) bool {
+	switch mode {
+	case ClientCertRequire, ClientCertOptional, ClientCertNone:
+		return true
+	}
+
+	return false
+}
+
+// PostCache describes which POST requests of a route are cached. Their
+// cache key adds a hash of the normalized body to the route's CacheKey.
+type PostCache struct {
+	// MaxBodySize is the size of the largest body cached, in bytes.
+	MaxBodySize int `json:"maxBodySize,omitempty"`
+	// ContentTypes lists the media types of the bodies cached. JSON bodies
+	// are canonicalized before being hashed.
+	ContentTypes []string `json:"contentTypes,omitempty"`
+	// GraphQL caches GraphQL queries only, never mutations or
+	// subscriptions, and keys persisted queries on their ID.
+	GraphQL bool `json:"graphql,omitempty"`
+}
+
+// Defaults of PostCache.
+const (
+	DefaultPostMaxBodySize = 64 * 1024
+	DefaultPostContentType = "application/json"
+)
+
+// Table matches requests to routes.
+type Table struct {
+	// routes are sorted by decreasing path length, so that the first match
+	// is the most specific one.
+	routes []Route
+}
+
+// defaultRoute applies to requests no route matches.
+var defaultRoute = &Route{Path: "/"}
+
+// New returns a table of routes, validating them.
+func New(routes []Route) (*Table, error) {
+	routes = slices.Clone(routes)
+
+	for i, route := range routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return nil, fmt.Errorf("route %d: path %q doesn't start with /", i, route.Path)
+		}
+
+		if err := route.CacheKey.validate(); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.Path, err)
+		}
+
+		if route.ClientCert != "" && !ValidClientCert(route.ClientCert) {
+			return nil, fmt.Errorf("route %s: invalid client cert mode %q", route.Path, route.ClientCert)
+		}
+
+		if post := route.Post; post != nil {
+			if post.MaxBodySize < 0 {
+				return nil, fmt.Errorf("route %s: negative post max body size %d", route.Path, post.MaxBodySize)
+			}
+
+			post = &PostCache{
+				MaxBodySize:  cmp.Or(post.MaxBodySize, DefaultPostMaxBodySize),
+				ContentTypes: post.ContentTypes,
+				GraphQL:      post.GraphQL,
+			}
+
+			if len(post.ContentTypes) == 0 {
+				post.ContentTypes = []string{DefaultPostContentType}
+			}
+
+			routes[i].Post = post
+		}
+	}
+
+	slices.SortStableFunc(routes, func(a, b Route) int {
+		return len(b.Path) - len(a.Path)
+	})
+
+	return &Table{routes: routes}, nil
+}
+
+// Load reads the routes from the JSON file at path, an object with a routes
+// array. An empty path returns a table without routes.
+func Load(path string) (*Table, error) {
+	if path == "" {
+		return New(nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Routes []Route `json:"routes"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid routes file %s: %w", path, err)
+	}
+
+	return New(file.Routes)
+}
+
+// Match returns the route with the longest path that r's path is under, or
+// a default route without settings. r's path is expected to be clean, as
+// dot segments and repeated slashes would get around the routes.
+func (t *Table) Match(r *http.Request) *Route {
+	if t != nil {
+		for i := range t.routes {
+			if under(r.URL.Path, t.routes[i].Path) {
+				return &t.routes[i]
+			}
+		}
+	}
+
+	return defaultRoute
+}
+
+// under reports whether path is prefix or below it, ignoring case.
+func under(path, prefix string) bool {
+	if len(path) < len(prefix) || !strings.EqualFold(path[:len(prefix)], prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
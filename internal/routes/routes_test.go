@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestLoad(t *testing.T) {
+	eval := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "routes.json")
+	eval.NoErr(os.WriteFile(path, []byte(`{
+		"routes": [
+			{"path": "/api/", "cacheKey": {"sortQuery": true}},
+			{"path": "/api/search", "cacheKey": {"excludeQuery": ["utm_*"]}},
+			{"path": "/static/"},
+			{"path": "/graphql", "post": {"graphql": true}}
+		]
+	}`), 0o600))
+
+	table, err := Load(path)
+	eval.NoErr(err)
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "/api/search?q=1", want: "/api/search"},
+		{target: "/api/items", want: "/api/"},
+		{target: "/static/app.js", want: "/static/"},
+		{target: "/other", want: "/"},
+		{target: "/API/Items", want: "/api/"},
+		{target: "/graphql", want: "/graphql"},
+		{target: "/graphql/batch", want: "/graphql"},
+		{target: "/graphqlx", want: "/"},
+		{target: "/api", want: "/"},
+	}
+
+	for _, tt := range tests {
+		route := table.Match(httptest.NewRequest(http.MethodGet, tt.target, nil))
+		eval.Equal(route.Path, tt.want)
+	}
+
+	eval.True(table.Match(httptest.NewRequest(http.MethodGet, "/other", nil)).CacheKey == nil)
+
+	post := table.Match(httptest.NewRequest(http.MethodPost, "/graphql", nil)).Post
+	eval.Equal(post, &PostCache{
+		MaxBodySize:  DefaultPostMaxBodySize,
+		ContentTypes: []string{DefaultPostContentType},
+		GraphQL:      true,
+	})
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "syntax", data: `{"routes": [`},
+		{name: "relative path", data: `{"routes": [{"path": "api"}]}`},
+		{name: "empty name", data: `{"routes": [{"path": "/", "cacheKey": {"headers": [""]}}]}`},
+		{name: "negative body size", data: `{"routes": [{"path": "/", "post": {"maxBodySize": -1}}]}`},
+		{name: "client cert mode", data: `{"routes": [{"path": "/", "clientCert": "always"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			path := filepath.Join(t.TempDir(), "routes.json")
+			eval.NoErr(os.WriteFile(path, []byte(tt.data), 0o600))
+
+			_, err := Load(path)
+			eval.True(err != nil)
+		})
+	}
+}
+
+func TestLoadEmpty(t *testing.T) {
+	eval := is.New(t)
+
+	table, err := Load("")
+	eval.NoErr(err)
+	eval.Equal(table.Match(httptest.NewRequest(http.MethodGet, "/", nil)).Path, "/")
+}
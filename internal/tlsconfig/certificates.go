@@ -0,0 +1,106 @@
+// Package tlsconfig builds the TLS configurations of the proxy's listener
+// and of its connections to the upstream from files.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Certificates holds certificate chains loaded from files and selects the
+// one to present by SNI.
+type Certificates struct {
+	certFiles []string
+	keyFiles  []string
+
+	mu    sync.RWMutex
+	certs []*tls.Certificate
+	// stamps identify the versions of the files the certificates were
+	// loaded from.
+	stamps []fileStamp
+}
+
+// LoadCertificates loads the certificate chains in certFiles along with the
+// keys in keyFiles, paired by position. The first one is presented to
+// clients whose server name none of them matches.
+func LoadCertificates(certFiles, keyFiles []string) (*Certificates, error) {
+	if len(certFiles) == 0 {
+		return nil, errors.New("no certificate file")
+	}
+
+	if len(certFiles) != len(keyFiles) {
+		return nil, fmt.Errorf("%d certificate files but %d key files", len(certFiles), len(keyFiles))
+	}
+
+	c := &Certificates{
+		certFiles: certFiles,
+		keyFiles:  keyFiles,
+	}
+
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate returns the first certificate chain that supports the
+// client's hello, server name included, or the first one if none does. It
+// is meant for tls.Config.GetCertificate.
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	certs := c.certs
+	c.mu.RUnlock()
+
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+
+	return certs[0], nil
+}
+
+// Reload loads the certificate chains again if one of their files changed,
+// and reports whether it did. The chains loaded before are kept if one of
+// them fails to load.
+func (c *Certificates) Reload() (bool, error) {
+	stamps, err := statFiles(c.Files())
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	changed := !slices.Equal(stamps, c.stamps)
+	c.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	certs := make([]*tls.Certificate, len(c.certFiles))
+
+	for i := range c.certFiles {
+		cert, err := tls.LoadX509KeyPair(c.certFiles[i], c.keyFiles[i])
+		if err != nil {
+			return false, fmt.Errorf("failed to load certificate %s: %w", c.certFiles[i], err)
+		}
+
+		certs[i] = &cert
+	}
+
+	c.mu.Lock()
+	c.certs = certs
+	c.stamps = stamps
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+// Files returns the paths of the files the certificates are loaded from.
+func (c *Certificates) Files() []string {
+	return slices.Concat(c.certFiles, c.keyFiles)
+}
@@ -0,0 +1,245 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// testCert is a certificate generated for a test along with its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCert generates a certificate from template, signed by parent or
+// self-signed if parent is nil.
+func newCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, key: key}
+}
+
+func newCA(t *testing.T, name string) *testCert {
+	t.Helper()
+
+	return newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil)
+}
+
+// write writes the certificate and its key to dir, and returns the paths
+// of both files.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.cert.Raw},
+		PrivateKey:  c.key,
+		Leaf:        c.cert,
+	}
+}
+
+// writeCertificate writes a self-signed server certificate for name and its
+// key to dir, and returns the paths of both files.
+func writeCertificate(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	return newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{name},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil).write(t, dir, name)
+}
+
+// serverCertificate returns the certificate the server at addr presents to
+// clients asking for serverName.
+func serverCertificate(t *testing.T, addr, serverName string) *x509.Certificate {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+// newTLSServer starts a server presenting certs and returns its address.
+// httptest.Server isn't used as it presents its own certificate to clients
+// that don't send a server name.
+func newTLSServer(t *testing.T, certs *Certificates) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	go srv.Serve(tls.NewListener(ln, &tls.Config{GetCertificate: certs.GetCertificate}))
+	t.Cleanup(func() { srv.Close() })
+
+	return ln.Addr().String()
+}
+
+func TestGetCertificate(t *testing.T) {
+	eval := is.New(t)
+
+	dir := t.TempDir()
+	comCert, comKey := writeCertificate(t, dir, "example.com")
+	orgCert, orgKey := writeCertificate(t, dir, "example.org")
+
+	certs, err := LoadCertificates([]string{comCert, orgCert}, []string{comKey, orgKey})
+	eval.NoErr(err)
+
+	addr := newTLSServer(t, certs)
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{serverName: "example.com", want: "example.com"},
+		{serverName: "example.org", want: "example.org"},
+		{serverName: "example.net", want: "example.com"},
+		{serverName: "", want: "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			eval := is.New(t)
+
+			eval.Equal(serverCertificate(t, addr, tt.serverName).Subject.CommonName, tt.want)
+		})
+	}
+}
+
+func TestLoadCertificatesErrors(t *testing.T) {
+	eval := is.New(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "example.com")
+	_, otherKey := writeCertificate(t, dir, "example.org")
+
+	_, err := LoadCertificates(nil, nil)
+	eval.True(err != nil) // no certificate
+
+	_, err = LoadCertificates([]string{certFile}, nil)
+	eval.True(err != nil) // missing key file
+
+	_, err = LoadCertificates([]string{certFile}, []string{otherKey})
+	eval.True(err != nil) // key doesn't match
+
+	_, err = LoadCertificates([]string{certFile}, []string{filepath.Join(dir, "missing.key")})
+	eval.True(err != nil)
+
+	_, err = LoadCertificates([]string{certFile}, []string{keyFile})
+	eval.NoErr(err)
+}
+
+func TestReload(t *testing.T) {
+	eval := is.New(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "example.com")
+
+	certs, err := LoadCertificates([]string{certFile}, []string{keyFile})
+	eval.NoErr(err)
+
+	addr := newTLSServer(t, certs)
+	before := serverCertificate(t, addr, "example.com")
+
+	reloaded, err := certs.Reload()
+	eval.NoErr(err)
+	eval.True(!reloaded) // unchanged files
+
+	// Rotate the certificate, making sure the modification time changes
+	// however coarse the filesystem's clock.
+	writeCertificate(t, dir, "example.com")
+	future := time.Now().Add(time.Minute)
+	eval.NoErr(os.Chtimes(certFile, future, future))
+
+	reloaded, err = certs.Reload()
+	eval.NoErr(err)
+	eval.True(reloaded)
+
+	after := serverCertificate(t, addr, "example.com")
+	eval.True(before.SerialNumber.Cmp(after.SerialNumber) != 0)
+
+	// A broken pair keeps the certificate loaded before.
+	eval.NoErr(os.WriteFile(keyFile, []byte("not a key"), 0o600))
+
+	_, err = certs.Reload()
+	eval.True(err != nil)
+	eval.Equal(serverCertificate(t, addr, "example.com").SerialNumber, after.SerialNumber)
+}
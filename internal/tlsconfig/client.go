@@ -0,0 +1,95 @@
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+)
+
+// NewClient returns the TLS configuration of connections to an upstream,
+// or nil if cfg leaves Go's defaults.
+func NewClient(cfg *config.UpstreamTLSConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.KeyFile == "" && cfg.ServerName == "" && len(cfg.PinnedSPKI) == 0 && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pool, _, err := loadCAs(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", cfg.CertFile, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(cfg.PinnedSPKI) > 0 {
+		pins, err := parsePins(cfg.PinnedSPKI)
+		if err != nil {
+			return nil, err
+		}
+
+		// VerifyConnection runs even when the chain isn't verified.
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkPins(cs, pins)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// parsePins decodes base64 SHA-256 hashes of SubjectPublicKeyInfos, as in
+// the pin-sha256 of HPKP.
+func parsePins(encoded []string) (map[[sha256.Size]byte]bool, error) {
+	pins := make(map[[sha256.Size]byte]bool, len(encoded))
+
+	for _, s := range encoded {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid spki pin %q, want a base64 sha-256 hash", s)
+		}
+
+		pins[[sha256.Size]byte(b)] = true
+	}
+
+	return pins, nil
+}
+
+// checkPins returns an error unless a certificate of the verified chains
+// has a pinned public key. If the chains weren't verified, only the leaf
+// counts: the server proved it holds its key, while it may send any other
+// certificate along.
+func checkPins(cs tls.ConnectionState, pins map[[sha256.Size]byte]bool) error {
+	chains := cs.VerifiedChains
+	if len(chains) == 0 && len(cs.PeerCertificates) > 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("no pinned public key in the upstream's certificates")
+}
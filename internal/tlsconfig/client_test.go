@@ -0,0 +1,182 @@
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func spkiPin(c *testCert) string {
+	sum := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestNewClient(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newCA(t, "ca")
+	caFile := filepath.Join(dir, "ca.pem")
+	is.New(t).NoErr(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+
+	server := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "upstream.internal"},
+		DNSNames:    []string{"upstream.internal"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+
+	client := newClientCert(t, ca, "proxy")
+	certFile, keyFile := client.write(t, dir, "proxy")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	// The upstream requires a client certificate signed by the CA.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	withCert := func(cfg config.UpstreamTLSConfig) config.UpstreamTLSConfig {
+		cfg.CertFile, cfg.KeyFile = certFile, keyFile
+
+		return cfg
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.UpstreamTLSConfig
+		wantErr bool
+	}{
+		{name: "trusted", cfg: withCert(config.UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal"})},
+		{name: "system roots", cfg: withCert(config.UpstreamTLSConfig{ServerName: "upstream.internal"}), wantErr: true},
+		{name: "wrong server name", cfg: withCert(config.UpstreamTLSConfig{CAFile: caFile}), wantErr: true},
+		{name: "no client certificate", cfg: config.UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal"}, wantErr: true},
+		{name: "pinned leaf", cfg: withCert(config.UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal", PinnedSPKI: []string{spkiPin(server)}})},
+		{name: "pinned ca", cfg: withCert(config.UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal", PinnedSPKI: []string{spkiPin(client), spkiPin(ca)}})},
+		{name: "pin mismatch", cfg: withCert(config.UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal", PinnedSPKI: []string{spkiPin(client)}}), wantErr: true},
+		{name: "insecure", cfg: withCert(config.UpstreamTLSConfig{InsecureSkipVerify: true})},
+		{name: "insecure pinned", cfg: withCert(config.UpstreamTLSConfig{InsecureSkipVerify: true, PinnedSPKI: []string{spkiPin(server)}})},
+		{name: "insecure pin mismatch", cfg: withCert(config.UpstreamTLSConfig{InsecureSkipVerify: true, PinnedSPKI: []string{spkiPin(ca)}}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			tlsConfig, err := NewClient(&tt.cfg)
+			eval.NoErr(err)
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get(srv.URL)
+			if tt.wantErr {
+				eval.True(err != nil)
+
+				return
+			}
+
+			eval.NoErr(err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			eval.NoErr(err)
+			eval.Equal(string(body), "proxy")
+		})
+	}
+}
+
+func TestNewClientInsecurePinnedLeaf(t *testing.T) {
+	ca := newCA(t, "ca")
+
+	// A server that doesn't hold the pinned key sends its certificate along.
+	mallory := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mallory"},
+		DNSNames:    []string{"upstream.internal"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+
+	cert := mallory.tlsCertificate()
+	cert.Certificate = append(cert.Certificate, ca.cert.Raw)
+
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		pin     *testCert
+		wantErr bool
+	}{
+		{name: "pinned leaf", pin: mallory},
+		{name: "pinned certificate sent along", pin: ca, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			tlsConfig, err := NewClient(&config.UpstreamTLSConfig{InsecureSkipVerify: true, PinnedSPKI: []string{spkiPin(tt.pin)}})
+			eval.NoErr(err)
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get(srv.URL)
+			if tt.wantErr {
+				eval.True(err != nil)
+
+				return
+			}
+
+			eval.NoErr(err)
+			_ = resp.Body.Close()
+		})
+	}
+}
+
+func TestNewClientErrors(t *testing.T) {
+	eval := is.New(t)
+
+	tlsConfig, err := NewClient(&config.UpstreamTLSConfig{})
+	eval.NoErr(err)
+	eval.Equal(tlsConfig, nil) // Go's defaults
+
+	_, err = NewClient(&config.UpstreamTLSConfig{PinnedSPKI: []string{"not base64"}})
+	eval.True(err != nil)
+
+	_, err = NewClient(&config.UpstreamTLSConfig{PinnedSPKI: []string{base64.StdEncoding.EncodeToString([]byte("short"))}})
+	eval.True(err != nil)
+
+	_, err = NewClient(&config.UpstreamTLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	eval.True(err != nil)
+
+	certFile, _ := writeCertificate(t, t.TempDir(), "example.com")
+	_, err = NewClient(&config.UpstreamTLSConfig{CertFile: certFile})
+	eval.True(err != nil) // no key
+}
@@ -0,0 +1,140 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"slices"
+	"sync"
+)
+
+// RevocationList holds the certificates revoked by the CRLs in a file, PEM
+// or DER encoded. Each CRL must be signed by one of the trusted CAs.
+type RevocationList struct {
+	file string
+	cas  []*x509.Certificate
+
+	mu sync.RWMutex
+	// revoked holds the revoked certificates, by issuer and serial number.
+	revoked map[string]struct{}
+	stamps  []fileStamp
+}
+
+// LoadRevocationList loads the CRLs in file, checking they are signed by
+// one of cas.
+func LoadRevocationList(file string, cas []*x509.Certificate) (*RevocationList, error) {
+	l := &RevocationList{
+		file: file,
+		cas:  cas,
+	}
+
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Revoked reports whether cert was revoked by its issuer.
+func (l *RevocationList) Revoked(cert *x509.Certificate) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.revoked[revocationKey(cert.RawIssuer, cert.SerialNumber.Bytes())]
+
+	return ok
+}
+
+// Reload loads the CRLs again if their file changed, and reports whether it
+// did. The CRLs loaded before are kept if the file is invalid.
+func (l *RevocationList) Reload() (bool, error) {
+	stamps, err := statFiles(l.Files())
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.RLock()
+	changed := !slices.Equal(stamps, l.stamps)
+	l.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(l.file)
+	if err != nil {
+		return false, err
+	}
+
+	revoked := make(map[string]struct{})
+
+	for der := range crlBlocks(data) {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return false, fmt.Errorf("invalid crl in %s: %w", l.file, err)
+		}
+
+		if err := l.checkSignature(crl); err != nil {
+			return false, fmt.Errorf("invalid crl in %s: %w", l.file, err)
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			revoked[revocationKey(crl.RawIssuer, entry.SerialNumber.Bytes())] = struct{}{}
+		}
+	}
+
+	l.mu.Lock()
+	l.revoked = revoked
+	l.stamps = stamps
+	l.mu.Unlock()
+
+	return true, nil
+}
+
+// Files returns the path of the file the CRLs are loaded from.
+func (l *RevocationList) Files() []string {
+	return []string{l.file}
+}
+
+func (l *RevocationList) checkSignature(crl *x509.RevocationList) error {
+	for _, ca := range l.cas {
+		if bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+			return nil
+		}
+	}
+
+	return errors.New("crl not signed by a trusted ca")
+}
+
+// crlBlocks yields the DER encoded CRLs of a PEM file, or data itself if it
+// isn't PEM.
+func crlBlocks(data []byte) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		if !bytes.Contains(data, []byte("-----BEGIN")) {
+			yield(data)
+
+			return
+		}
+
+		rest := data
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				return
+			}
+
+			if block.Type == "X509 CRL" && !yield(block.Bytes) {
+				return
+			}
+		}
+	}
+}
+
+func revocationKey(issuer, serial []byte) string {
+	return hex.EncodeToString(issuer) + ":" + hex.EncodeToString(serial)
+}
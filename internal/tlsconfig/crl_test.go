@@ -0,0 +1,100 @@
+package tlsconfig
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// writeCRL writes a PEM CRL of ca revoking revoked to path.
+func writeCRL(t *testing.T, path string, ca *testCert, revoked ...*testCert) {
+	t.Helper()
+
+	template := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+
+	for _, c := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   c.cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the modification time changes however coarse the
+	// filesystem's clock.
+	stamp := time.Now().Add(time.Duration(len(revoked)) * time.Minute)
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newClientCert(t *testing.T, ca *testCert, name string) *testCert {
+	t.Helper()
+
+	return newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+func TestRevocationList(t *testing.T) {
+	eval := is.New(t)
+
+	ca := newCA(t, "ca")
+	alice := newClientCert(t, ca, "alice")
+	bob := newClientCert(t, ca, "bob")
+
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(t, path, ca, alice)
+
+	crl, err := LoadRevocationList(path, []*x509.Certificate{ca.cert})
+	eval.NoErr(err)
+	eval.True(crl.Revoked(alice.cert))
+	eval.True(!crl.Revoked(bob.cert))
+
+	// A certificate of another CA with the same serial number isn't
+	// revoked.
+	other := newCA(t, "other")
+	carol := newClientCert(t, other, "carol")
+	carol.cert.SerialNumber = alice.cert.SerialNumber
+	eval.True(!crl.Revoked(carol.cert))
+
+	writeCRL(t, path, ca, alice, bob)
+
+	reloaded, err := crl.Reload()
+	eval.NoErr(err)
+	eval.True(reloaded)
+	eval.True(crl.Revoked(bob.cert))
+
+	// A CRL not signed by a trusted CA is refused, keeping the one loaded
+	// before.
+	writeCRL(t, path, other, carol, carol, carol)
+
+	_, err = crl.Reload()
+	eval.True(err != nil)
+	eval.True(crl.Revoked(bob.cert))
+
+	_, err = LoadRevocationList(path, []*x509.Certificate{ca.cert})
+	eval.True(err != nil)
+}
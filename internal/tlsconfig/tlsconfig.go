@@ -0,0 +1,171 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+)
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion parses a TLS version written as 1.2 or 1.3.
+func ParseVersion(s string) (uint16, error) {
+	v, ok := versions[strings.TrimPrefix(strings.ToLower(s), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported tls version %q, want 1.0, 1.1, 1.2 or 1.3", s)
+	}
+
+	return v, nil
+}
+
+// ParseCipherSuites returns the IDs of the cipher suites named as in the
+// crypto/tls package, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only
+// the suites crypto/tls considers secure are accepted.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		ids[s.Name] = s.ID
+	}
+
+	suites := make([]uint16, 0, len(names))
+
+	for _, name := range names {
+		id, ok := ids[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported or insecure cipher suite %q", name)
+		}
+
+		suites = append(suites, id)
+	}
+
+	return suites, nil
+}
+
+// NewServer returns the TLS configuration of a listener along with what it
+// is loaded from, to be watched for changes. When cfg.ClientCAFile is set,
+// clients may present certificates, which are verified against it and
+// cfg.CRLFile.
+func NewServer(cfg *config.TLSConfig) (*tls.Config, []Reloader, error) {
+	minVersion, err := ParseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	suites, err := ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certs, err := LoadCertificates(cfg.CertFiles, cfg.KeyFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	reloaders := []Reloader{certs}
+
+	if cfg.ClientCAFile == "" {
+		if cfg.CRLFile != "" {
+			return nil, nil, errors.New("crl file without client ca file")
+		}
+
+		return tlsConfig, reloaders, nil
+	}
+
+	pool, cas, err := loadCAs(cfg.ClientCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Whether a certificate is required depends on the route, which the
+	// handshake doesn't know.
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	tlsConfig.ClientCAs = pool
+
+	if cfg.CRLFile != "" {
+		crl, err := LoadRevocationList(cfg.CRLFile, cas)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkRevocation(cs.VerifiedChains, crl)
+		}
+
+		reloaders = append(reloaders, crl)
+	}
+
+	return tlsConfig, reloaders, nil
+}
+
+// checkRevocation returns an error if a certificate of chains, but their
+// roots, is revoked.
+func checkRevocation(chains [][]*x509.Certificate, crl *RevocationList) error {
+	for _, chain := range chains {
+		for _, cert := range chain[:len(chain)-1] {
+			if crl.Revoked(cert) {
+				return fmt.Errorf("certificate %s of %s is revoked", cert.SerialNumber, cert.Subject)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadCAs reads the PEM certificates of the bundle at path, returning them
+// in a pool and as a list.
+func loadCAs(path string) (*x509.CertPool, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	var certs []*x509.Certificate
+
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+		}
+
+		pool.AddCert(cert)
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("no certificate in %s", path)
+	}
+
+	return pool, certs, nil
+}
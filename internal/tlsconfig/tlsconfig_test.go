@@ -0,0 +1,178 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/komaldsukhani/reverseproxyexample/internal/config"
+	"github.com/matryer/is"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{in: "1.2", want: tls.VersionTLS12},
+		{in: "1.3", want: tls.VersionTLS13},
+		{in: "TLS1.3", want: tls.VersionTLS13},
+		{in: "1.4", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			eval := is.New(t)
+
+			got, err := ParseVersion(tt.in)
+			eval.Equal(err != nil, tt.wantErr)
+			eval.Equal(got, tt.want)
+		})
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	eval := is.New(t)
+
+	suites, err := ParseCipherSuites(nil)
+	eval.NoErr(err)
+	eval.Equal(suites, nil)
+
+	suites, err = ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"})
+	eval.NoErr(err)
+	eval.Equal(suites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256})
+
+	_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	eval.True(err != nil) // insecure
+
+	_, err = ParseCipherSuites([]string{"TLS_UNKNOWN"})
+	eval.True(err != nil)
+}
+
+func TestNewServer(t *testing.T) {
+	eval := is.New(t)
+
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "example.com")
+
+	cfg := config.TLSConfig{
+		CertFiles:    []string{certFile},
+		KeyFiles:     []string{keyFile},
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	}
+
+	tlsConfig, reloaders, err := NewServer(&cfg)
+	eval.NoErr(err)
+	eval.Equal(len(reloaders), 1)
+	eval.Equal(tlsConfig.MinVersion, uint16(tls.VersionTLS13))
+	eval.Equal(tlsConfig.CipherSuites, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256})
+
+	cfg.MinVersion = "2"
+	_, _, err = NewServer(&cfg)
+	eval.True(err != nil)
+}
+
+func TestNewServerClientAuth(t *testing.T) {
+	eval := is.New(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "example.com")
+
+	ca := newCA(t, "ca")
+	alice := newClientCert(t, ca, "alice")
+	bob := newClientCert(t, ca, "bob")
+	mallory := newClientCert(t, newCA(t, "other"), "mallory")
+
+	caFile := filepath.Join(dir, "ca.pem")
+	eval.NoErr(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+
+	crlFile := filepath.Join(dir, "ca.crl")
+	writeCRL(t, crlFile, ca, bob)
+
+	tlsConfig, reloaders, err := NewServer(&config.TLSConfig{
+		CertFiles:    []string{certFile},
+		KeyFiles:     []string{keyFile},
+		MinVersion:   "1.2",
+		ClientCAFile: caFile,
+		CRLFile:      crlFile,
+	})
+	eval.NoErr(err)
+	eval.Equal(len(reloaders), 2)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	eval.NoErr(err)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			for _, chain := range r.TLS.VerifiedChains {
+				fmt.Fprint(rw, chain[0].Subject.CommonName)
+			}
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go srv.Serve(tls.NewListener(ln, tlsConfig))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		cert    *testCert
+		want    string
+		wantErr bool
+	}{
+		{name: "no certificate", want: ""},
+		{name: "verified", cert: alice, want: "alice"},
+		{name: "revoked", cert: bob, wantErr: true},
+		{name: "untrusted", cert: mallory, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eval := is.New(t)
+
+			clientConfig := &tls.Config{InsecureSkipVerify: true}
+			if tt.cert != nil {
+				// The certificate is sent even when the server doesn't
+				// accept its CA.
+				clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					cert := tt.cert.tlsCertificate()
+
+					return &cert, nil
+				}
+			}
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get("https://" + ln.Addr().String())
+			if tt.wantErr {
+				eval.True(err != nil)
+
+				return
+			}
+
+			eval.NoErr(err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			eval.NoErr(err)
+			eval.Equal(string(body), tt.want)
+		})
+	}
+
+	_, _, err = NewServer(&config.TLSConfig{
+		CertFiles:  []string{certFile},
+		KeyFiles:   []string{keyFile},
+		MinVersion: "1.2",
+		CRLFile:    crlFile,
+	})
+	eval.True(err != nil) // crl without ca
+}
@@ -0,0 +1,93 @@
+package tlsconfig
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Reloader is loaded from files, and loaded again when they change.
+type Reloader interface {
+	// Reload loads the files again if one of them changed, and reports
+	// whether it did.
+	Reload() (bool, error)
+	Files() []string
+}
+
+// Watcher reloads files in the background.
+type Watcher struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Watch checks the files of reloaders for changes every interval until the
+// returned watcher is closed. It returns nil if interval isn't positive.
+func Watch(interval time.Duration, reloaders ...Reloader) *Watcher {
+	if interval <= 0 {
+		return nil
+	}
+
+	w := &Watcher{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go w.watch(interval, reloaders)
+
+	return w
+}
+
+func (w *Watcher) watch(interval time.Duration, reloaders []Reloader) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			for _, r := range reloaders {
+				// Files replaced one at a time may not match for a moment,
+				// so failures are retried on the next tick.
+				if reloaded, err := r.Reload(); err != nil {
+					slog.Error("failed to reload tls files", "files", r.Files(), "error", err)
+				} else if reloaded {
+					slog.Info("Reloaded tls files", "files", r.Files())
+				}
+			}
+		}
+	}
+}
+
+// Close stops watching the files.
+func (w *Watcher) Close() error {
+	if w != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	return nil
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFiles returns what identifies the versions of the files at paths.
+func statFiles(paths []string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, 0, len(paths))
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+
+	return stamps, nil
+}
@@ -0,0 +1,46 @@
+package tlsconfig
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestWatch(t *testing.T) {
+	eval := is.New(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "example.com")
+
+	certs, err := LoadCertificates([]string{certFile}, []string{keyFile})
+	eval.NoErr(err)
+
+	addr := newTLSServer(t, certs)
+	before := serverCertificate(t, addr, "example.com")
+
+	watcher := Watch(10*time.Millisecond, certs)
+	defer watcher.Close()
+
+	writeCertificate(t, dir, "example.com")
+	future := time.Now().Add(time.Minute)
+	eval.NoErr(os.Chtimes(certFile, future, future))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for serverCertificate(t, addr, "example.com").SerialNumber.Cmp(before.SerialNumber) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchDisabled(t *testing.T) {
+	eval := is.New(t)
+
+	watcher := Watch(0)
+	eval.Equal(watcher, nil)
+	eval.NoErr(watcher.Close())
+}
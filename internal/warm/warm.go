@@ -0,0 +1,267 @@
+// Package warm pre-populates the cache by requesting a list of URLs through
+// the proxy's own caching path.
+package warm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxListSize is the size of the largest list of URLs read, that of the
+// largest sitemap the protocol allows.
+const MaxListSize = 50 * 1024 * 1024
+
+var (
+	ErrSitemapIndex = errors.New("sitemap indexes are not supported, list the sitemaps they refer to instead")
+	ErrListTooLarge = fmt.Errorf("url list larger than %d bytes", MaxListSize)
+)
+
+// Fetcher serves a request through the cache. It reports whether the
+// response was served from the cache or stored in it.
+type Fetcher interface {
+	ServeCached(rw http.ResponseWriter, r *http.Request) (cached bool)
+}
+
+// Options bound the load warming puts on the upstream.
+type Options struct {
+	// Concurrency is the number of requests in flight at once.
+	Concurrency int
+	// Rate is the maximum number of requests started per second. Zero
+	// doesn't limit the rate.
+	Rate float64
+}
+
+// Result describes the outcome of warming a single URL.
+type Result struct {
+	URL       string `json:"url"`
+	Status    int    `json:"status,omitempty"`
+	Size      int    `json:"size"`
+	Cacheable bool   `json:"cacheable"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Warmer struct {
+	fetcher Fetcher
+	opts    Options
+}
+
+func New(fetcher Fetcher, opts Options) *Warmer {
+	return &Warmer{
+		fetcher: fetcher,
+		opts:    opts,
+	}
+}
+
+// Warm requests every URL through the cache and returns the results in the
+// order of urls. Only the path and query of a URL are used. When ctx is
+// done, the URLs not yet requested are reported with its error.
+func (w *Warmer) Warm(ctx context.Context, urls []string) []Result {
+	results := make([]Result, len(urls))
+	jobs := make(chan int)
+
+	var tick <-chan time.Time
+	if w.opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / w.opts.Rate))
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for range max(w.opts.Concurrency, 1) {
+		wg.Go(func() {
+			for i := range jobs {
+				results[i] = w.warm(ctx, urls[i])
+			}
+		})
+	}
+
+	for i, u := range urls {
+		if tick != nil && i > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+
+		if ctx.Err() != nil {
+			results[i] = Result{URL: u, Error: ctx.Err().Error()}
+
+			continue
+		}
+
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (w *Warmer) warm(ctx context.Context, rawURL string) Result {
+	result := Result{URL: rawURL}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		result.Error = err.Error()
+
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		result.Error = err.Error()
+
+		return result
+	}
+
+	// Requests reaching the server carry the path and query only, which
+	// the cache key is made of.
+	req.URL = &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+
+	req.RequestURI = req.URL.RequestURI()
+	if u.Host != "" {
+		req.Host = u.Host
+	}
+
+	rw := &discardWriter{header: make(http.Header)}
+
+	result.Cacheable = w.fetcher.ServeCached(rw, req)
+	result.Status = rw.status
+	result.Size = rw.size
+
+	return result
+}
+
+// discardWriter records the status and size of a response, discarding its body.
+type discardWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *discardWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.size += len(b)
+
+	return len(b), nil
+}
+
+// Load reads URLs from source, a file path or an http(s) URL, holding either
+// a sitemap or one URL per line.
+func Load(ctx context.Context, source string) ([]string, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+
+		return ReadURLs(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", source, resp.Status)
+	}
+
+	return ReadURLs(resp.Body)
+}
+
+// ReadURLs reads URLs from a sitemap or from a list of one URL per line,
+// ignoring blank lines and lines starting with #. It returns
+// ErrListTooLarge past MaxListSize bytes.
+func ReadURLs(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxListSize {
+		return nil, ErrListTooLarge
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return readSitemap(data)
+	}
+
+	var urls []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		urls = append(urls, line)
+	}
+
+	return urls, scanner.Err()
+}
+
+type sitemap struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+func readSitemap(data []byte) ([]string, error) {
+	var s sitemap
+	if err := xml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid sitemap: %w", err)
+	}
+
+	switch s.XMLName.Local {
+	case "urlset":
+	case "sitemapindex":
+		return nil, ErrSitemapIndex
+	default:
+		return nil, fmt.Errorf("invalid sitemap: unexpected <%s> element", s.XMLName.Local)
+	}
+
+	urls := make([]string, 0, len(s.URLs))
+	for _, u := range s.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+
+	return urls, nil
+}
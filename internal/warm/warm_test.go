@@ -0,0 +1,145 @@
+package warm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+type fakeFetcher struct {
+	mu       sync.Mutex
+	requests []string
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func (f *fakeFetcher) ServeCached(rw http.ResponseWriter, r *http.Request) bool {
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+
+	for {
+		seen := f.maxSeen.Load()
+		if n <= seen || f.maxSeen.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Host+" "+r.URL.String())
+	f.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	if r.URL.Path == "/missing" {
+		http.NotFound(rw, r)
+
+		return false
+	}
+
+	_, _ = rw.Write([]byte("body of " + r.URL.Path))
+
+	return true
+}
+
+func TestWarm(t *testing.T) {
+	eval := is.New(t)
+
+	f := &fakeFetcher{}
+	urls := []string{"https://example.com/a?x=1", "/b", "https://example.com/missing", "https://example.com", "/c", "/d"}
+
+	results := New(f, Options{Concurrency: 2}).Warm(context.Background(), urls)
+	eval.Equal(len(results), len(urls))
+
+	eval.Equal(results[0], Result{URL: urls[0], Status: http.StatusOK, Size: len("body of /a"), Cacheable: true})
+	eval.Equal(results[2].Status, http.StatusNotFound)
+	eval.True(!results[2].Cacheable)
+
+	// requested by path and query, as the cache key is made of
+	eval.True(strings.Contains(strings.Join(f.requests, ","), "example.com /a?x=1"))
+	eval.True(strings.Contains(strings.Join(f.requests, ","), "example.com /,"))
+
+	eval.True(f.maxSeen.Load() <= 2)
+}
+
+func TestWarmRate(t *testing.T) {
+	eval := is.New(t)
+
+	start := time.Now()
+	results := New(&fakeFetcher{}, Options{Concurrency: 4, Rate: 50}).Warm(context.Background(), []string{"/a", "/b", "/c", "/d", "/e"})
+
+	// four intervals of 20ms between five requests
+	eval.True(time.Since(start) >= 80*time.Millisecond)
+	eval.Equal(len(results), 5)
+}
+
+func TestWarmCanceled(t *testing.T) {
+	eval := is.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := New(&fakeFetcher{}, Options{Concurrency: 1, Rate: 1}).Warm(ctx, []string{"/a", "/b"})
+	eval.Equal(results[1].Error, context.Canceled.Error())
+}
+
+func TestReadURLs(t *testing.T) {
+	eval := is.New(t)
+
+	urls, err := ReadURLs(strings.NewReader("# pages\nhttps://example.com/a\n\n  /b  \n"))
+	eval.NoErr(err)
+	eval.Equal(urls, []string{"https://example.com/a", "/b"})
+
+	urls, err = ReadURLs(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc><lastmod>2024-01-01</lastmod></url>
+  <url><loc> https://example.com/about </loc></url>
+</urlset>`))
+	eval.NoErr(err)
+	eval.Equal(urls, []string{"https://example.com/", "https://example.com/about"})
+
+	_, err = ReadURLs(strings.NewReader(`<sitemapindex><sitemap><loc>https://example.com/s.xml</loc></sitemap></sitemapindex>`))
+	eval.Equal(err, ErrSitemapIndex)
+
+	_, err = ReadURLs(strings.NewReader(`<urlset><url>`))
+	eval.True(err != nil)
+
+	_, err = ReadURLs(strings.NewReader(strings.Repeat("/\n", MaxListSize/2+1)))
+	eval.Equal(err, ErrListTooLarge)
+}
+
+func TestLoad(t *testing.T) {
+	eval := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	eval.NoErr(os.WriteFile(path, []byte("/a\n/b\n"), 0o644))
+
+	urls, err := Load(context.Background(), path)
+	eval.NoErr(err)
+	eval.Equal(urls, []string{"/a", "/b"})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sitemap.xml" {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write([]byte(`<urlset><url><loc>/c</loc></url></urlset>`))
+	}))
+	defer srv.Close()
+
+	urls, err = Load(context.Background(), srv.URL+"/sitemap.xml")
+	eval.NoErr(err)
+	eval.Equal(urls, []string{"/c"})
+
+	_, err = Load(context.Background(), srv.URL+"/missing.xml")
+	eval.True(err != nil)
+}